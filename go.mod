@@ -1,8 +1,13 @@
 module github.com/wanmei002/goutil
 
-go 1.16
+go 1.18
 
 require (
 	github.com/gomodule/redigo v1.8.5
 	google.golang.org/grpc v1.39.0
 )
+
+require (
+	github.com/golang/protobuf v1.4.3 // indirect
+	google.golang.org/protobuf v1.25.0 // indirect
+)
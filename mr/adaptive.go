@@ -0,0 +1,120 @@
+package mr
+
+import (
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    // 短期平均延迟超过长期平均延迟的多少倍时认为过载了
+    defaultAdaptiveTolerance = 2
+    // 过载时并发数乘以这个比例
+    defaultAdaptiveBackoff = 0.9
+    // 短期平均延迟的衰减系数, 大约是最近 10 个数据的平均值
+    adaptiveShortAlpha = 0.1
+    // 长期平均延迟的衰减系数, 大约是最近 100 个数据的平均值
+    adaptiveLongAlpha = 0.01
+    // 至少处理完这么多数据后才根据延迟判断是不是过载了
+    adaptiveWarmup = 10
+)
+
+// AdaptiveConfig 自适应并发的参数, 用 AIMD 算法调整 mapper 的并发数:
+// 所有位置都被占用且 mapper 成功、延迟正常时慢慢加大并发数, 每完成 limit 个数据大约加 1;
+// mapper 出错或者最近的平均延迟超过长期平均延迟的 Tolerance 倍时按 Backoff 比例减小
+// 比较的是延迟的变化, 延迟本身波动很大但是和并发数无关时不会一直减小并发数
+type AdaptiveConfig struct {
+    // 并发数的下限, 也是开始时的并发数, 小于 1 时按 1 处理
+    Min int
+    // 并发数的上限, 小于 Min 时按 Min 处理
+    Max int
+    // 最近的平均延迟超过长期平均延迟的多少倍时认为过载了, 小于等于 1 时按 2 处理
+    Tolerance float64
+    // 过载时并发数乘以这个比例, 取值 0~1, 不在这个范围内时按 0.9 处理
+    Backoff float64
+}
+
+// adaptiveController 根据 mapper 的延迟和错误调整 workerPool 的上限
+type adaptiveController struct {
+    lock         sync.Mutex
+    config       AdaptiveConfig
+    pool         *workerPool
+    limit        float64
+    // 成功处理完的数据个数
+    samples      int
+    // 最近的平均延迟和长期的平均延迟
+    shortLatency float64
+    longLatency  float64
+    // 当前的上限, 给统计和进度用
+    current      *int64
+}
+
+func newAdaptiveController(config AdaptiveConfig, pool *workerPool, current *int64) *adaptiveController {
+    if config.Min < 1 {
+        config.Min = 1
+    }
+    if config.Max < config.Min {
+        config.Max = config.Min
+    }
+    if config.Tolerance <= 1 {
+        config.Tolerance = defaultAdaptiveTolerance
+    }
+    if config.Backoff <= 0 || config.Backoff >= 1 {
+        config.Backoff = defaultAdaptiveBackoff
+    }
+
+    c := &adaptiveController{
+        config:  config,
+        pool:    pool,
+        limit:   float64(config.Min),
+        current: current,
+    }
+    pool.setLimit(config.Min)
+    atomic.StoreInt64(current, int64(config.Min))
+
+    return c
+}
+
+// observe 每个数据处理完后调用
+func (c *adaptiveController) observe(latency time.Duration, failed bool) {
+    saturated := c.pool.saturated()
+
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    overloaded := failed
+    if !failed {
+        c.samples++
+        c.shortLatency = ewma(c.shortLatency, float64(latency), adaptiveShortAlpha, c.samples)
+        c.longLatency = ewma(c.longLatency, float64(latency), adaptiveLongAlpha, c.samples)
+        overloaded = c.samples >= adaptiveWarmup && c.shortLatency > c.longLatency*c.config.Tolerance
+    }
+
+    switch {
+    case overloaded:
+        c.limit *= c.config.Backoff
+        if c.limit < float64(c.config.Min) {
+            c.limit = float64(c.config.Min)
+        }
+    case saturated:
+        // 并发数没用满时加大也没有意义
+        c.limit += 1 / c.limit
+        if c.limit > float64(c.config.Max) {
+            c.limit = float64(c.config.Max)
+        }
+    default:
+        return
+    }
+
+    limit := int(c.limit)
+    c.pool.setLimit(limit)
+    atomic.StoreInt64(c.current, int64(limit))
+}
+
+// ewma 计算指数移动平均, 前几个数据按算术平均计算, 避免一开始被第一个数据带偏
+func ewma(avg, val, alpha float64, n int) float64 {
+    if w := 1 / float64(n); w > alpha {
+        alpha = w
+    }
+    return avg + (val-avg)*alpha
+}
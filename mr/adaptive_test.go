@@ -0,0 +1,107 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "math/rand"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestMapReduceAdaptiveGrows(t *testing.T) {
+    var stats Stats
+    _, err := MapReduceOf(context.Background(), generateInts(500), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        time.Sleep(time.Millisecond)
+        writer.Writer(item)
+    }, countInts, WithAdaptiveConcurrency(AdaptiveConfig{Min: 2, Max: 32, Tolerance: 20}), WithStats(&stats))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stats.Workers <= 2 || stats.Workers > 32 {
+        t.Fatalf("want workers grown within (2, 32], got %d", stats.Workers)
+    }
+}
+
+func TestMapReduceAdaptiveVaryingLatency(t *testing.T) {
+    var (
+        stats   Stats
+        lock    sync.Mutex
+        workers []int
+    )
+    // 延迟在 1~5ms 之间随机波动, 和并发数无关, 不能被当成过载
+    _, err := MapReduceOf(context.Background(), generateInts(1000), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        time.Sleep(time.Duration(1+rand.Intn(5)) * time.Millisecond)
+        writer.Writer(item)
+    }, countInts, WithAdaptiveConcurrency(AdaptiveConfig{Min: 1, Max: 64}), WithStats(&stats),
+        WithProgress(func(p Progress) {
+            lock.Lock()
+            workers = append(workers, p.Workers)
+            lock.Unlock()
+        }), WithProgressInterval(5*time.Millisecond))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stats.Workers < 8 {
+        t.Fatalf("want workers grown with varying latency, got %d", stats.Workers)
+    }
+
+    lock.Lock()
+    defer lock.Unlock()
+    var grown bool
+    for _, n := range workers[:len(workers)-1] {
+        if n > 1 {
+            grown = true
+        }
+    }
+    if !grown {
+        t.Fatalf("want the current limit visible while running, got %v", workers)
+    }
+}
+
+func TestMapReduceAdaptiveShrinks(t *testing.T) {
+    var stats Stats
+    _, err := MapReduceOf(context.Background(), generateInts(200), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        if item >= 100 {
+            cancel(errors.New("overloaded"))
+        }
+    }, countInts, WithAdaptiveConcurrency(AdaptiveConfig{Min: 1, Max: 8, Tolerance: 1000}),
+        WithContinueOnError(), WithStats(&stats))
+    if err == nil {
+        t.Fatal("want errors")
+    }
+    if stats.Workers != 1 {
+        t.Fatalf("want workers back to 1 after errors, got %d", stats.Workers)
+    }
+}
+
+func TestWorkerPoolSetLimit(t *testing.T) {
+    pool := newWorkerPool(1)
+    done := make(chan struct{})
+    if !pool.acquire(done) {
+        t.Fatal("want acquired")
+    }
+    acquired := make(chan bool)
+    go func() {
+        acquired <- pool.acquire(done)
+    }()
+    select {
+    case <-acquired:
+        t.Fatal("want blocked at limit")
+    case <-time.After(10 * time.Millisecond):
+    }
+    pool.setLimit(2)
+    if !<-acquired {
+        t.Fatal("want acquired after limit raised")
+    }
+    go func() {
+        acquired <- pool.acquire(done)
+    }()
+    close(done)
+    if <-acquired {
+        t.Fatal("want not acquired after done")
+    }
+}
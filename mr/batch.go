@@ -0,0 +1,92 @@
+package mr
+
+import (
+    "context"
+    "time"
+)
+
+const (
+    // 默认每批最多的数据个数
+    defaultBatchSize = 100
+)
+
+// MapBatches 把 generate 写入的数据攒成一批再交给 mapper, 适合批量查询的场景, 比如 redis MGET、SQL IN
+// 每批最多 size 个数据, 第一个数据进来后超过 maxWait 还没攒满也会交给 mapper, 通过 WithBatch 设置
+// 每批数据占用一个 mapper 协程, 取消、出错的处理和 MapReduceOf 一样
+func MapBatches[T, U, V any](ctx context.Context, generate GenerateFuncOf[T], mapper MapperFuncOf[[]T, U],
+    reducer ReducerFuncOf[U, V], opts ...Option) (V, error) {
+    options := buildOptions(opts...)
+
+    return MapReduceOf(ctx, func(ctx context.Context, source chan<- []T, cancel func(err error)) {
+        in, stop := innerSource(ctx, generate, cancel)
+        defer stop()
+
+        batchItems(ctx, in, source, options.batchSize, options.batchWait)
+    }, mapper, reducer, opts...)
+}
+
+// batchItems 把 in 里的数据攒满 size 个或者等待超过 maxWait 后写入 source
+func batchItems[T any](ctx context.Context, in <-chan T, source chan<- []T, size int, maxWait time.Duration) {
+    var (
+        batch   []T
+        timer   *time.Timer
+        timeout <-chan time.Time
+    )
+    defer func() {
+        if timer != nil {
+            timer.Stop()
+        }
+    }()
+
+    flush := func() bool {
+        if timer != nil && !timer.Stop() {
+            // 把已经到期的通知读掉, 不然下次 Reset 后会马上触发
+            select {
+            case <-timer.C:
+            default:
+            }
+        }
+        timeout = nil
+        if len(batch) == 0 {
+            return true
+        }
+        select {
+        case <-ctx.Done():
+            return false
+        case source <- batch:
+            batch = nil
+            return true
+        }
+    }
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-timeout:
+            if !flush() {
+                return
+            }
+        case item, ok := <-in:
+            if !ok {
+                flush()
+                return
+            }
+            if batch == nil {
+                batch = make([]T, 0, size)
+                if maxWait > 0 {
+                    if timer == nil {
+                        timer = time.NewTimer(maxWait)
+                    } else {
+                        timer.Reset(maxWait)
+                    }
+                    timeout = timer.C
+                }
+            }
+            batch = append(batch, item)
+            if len(batch) >= size && !flush() {
+                return
+            }
+        }
+    }
+}
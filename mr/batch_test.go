@@ -0,0 +1,57 @@
+package mr
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestMapBatches(t *testing.T) {
+    res, err := MapBatches(context.Background(), generateInts(25), func(ctx context.Context, batch []int,
+        writer WriterOf[int], cancel func(err error)) {
+        if len(batch) > 10 {
+            cancel(nil)
+            return
+        }
+        writer.Writer(len(batch))
+    }, func(ctx context.Context, pipe <-chan int, writer WriterOf[[]int], cancel func(err error)) {
+        var sizes []int
+        for size := range pipe {
+            sizes = append(sizes, size)
+        }
+        writer.Writer(sizes)
+    }, WithBatch(10, 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var total int
+    for _, size := range res {
+        total += size
+    }
+    if len(res) != 3 || total != 25 {
+        t.Fatalf("want 3 batches of 25 items, got %v", res)
+    }
+}
+
+func TestMapBatchesMaxWait(t *testing.T) {
+    res, err := MapBatches(context.Background(), func(ctx context.Context, source chan<- int, cancel func(err error)) {
+        source <- 1
+        source <- 2
+        time.Sleep(50 * time.Millisecond)
+        source <- 3
+    }, func(ctx context.Context, batch []int, writer WriterOf[int], cancel func(err error)) {
+        writer.Writer(len(batch))
+    }, func(ctx context.Context, pipe <-chan int, writer WriterOf[int], cancel func(err error)) {
+        var n int
+        for range pipe {
+            n++
+        }
+        writer.Writer(n)
+    }, WithBatch(10, 5*time.Millisecond))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res != 2 {
+        t.Fatalf("want 2 batches, got %d", res)
+    }
+}
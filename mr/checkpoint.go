@@ -0,0 +1,217 @@
+package mr
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "os"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// 检查点: 记录已经处理完的数据, 长时间运行的任务中途退出后重新运行时跳过这些数据
+// 数据通过 WithCheckpoint 的 key 方法转成字符串来识别, 只有 mapper 成功执行完的数据才会被记录,
+// 出错、panic、超时跳过或者任务结束时还没执行完的数据下次会重新处理
+
+const (
+    // 默认多久把检查点写入一次
+    defaultCheckpointInterval = 5 * time.Second
+)
+
+// Checkpoint 保存处理完的数据的 key, 方法会被多个 mapper 协程同时调用, 要并发安全
+type Checkpoint interface {
+    // Done 返回 key 对应的数据是不是已经处理完了
+    Done(key string) bool
+    // Mark 记录 key 对应的数据已经处理完了, 可以先放在内存里
+    Mark(key string) error
+    // Flush 把 Mark 记录的 key 持久化
+    Flush() error
+}
+
+// FileCheckpoint 把 key 保存在文件里, 一行一个, 只追加不修改
+type FileCheckpoint struct {
+    lock    sync.Mutex
+    file    *os.File
+    done    map[string]struct{}
+    pending []string
+}
+
+// OpenFileCheckpoint 打开 path 对应的检查点文件, 文件不存在时创建
+// 进程在写入时退出可能留下不完整的最后一行, 打开时会把它去掉
+func OpenFileCheckpoint(path string) (*FileCheckpoint, error) {
+    data, err := os.ReadFile(path)
+    if err != nil && !os.IsNotExist(err) {
+        return nil, err
+    }
+    // 只保留到最后一个换行符
+    data = data[:bytes.LastIndexByte(data, '\n')+1]
+
+    done := make(map[string]struct{})
+    for _, line := range bytes.Split(data, []byte{'\n'}) {
+        if len(line) == 0 {
+            continue
+        }
+        key, err := strconv.Unquote(string(line))
+        if err != nil {
+            return nil, fmt.Errorf("mapreduce: bad checkpoint line %q in %s", line, path)
+        }
+        done[key] = struct{}{}
+    }
+
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, err
+    }
+    if err := file.Truncate(int64(len(data))); err != nil {
+        file.Close()
+        return nil, err
+    }
+
+    return &FileCheckpoint{
+        file: file,
+        done: done,
+    }, nil
+}
+
+func (c *FileCheckpoint) Done(key string) bool {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    _, ok := c.done[key]
+    return ok
+}
+
+func (c *FileCheckpoint) Mark(key string) error {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    if _, ok := c.done[key]; ok {
+        return nil
+    }
+    c.done[key] = struct{}{}
+    c.pending = append(c.pending, key)
+    return nil
+}
+
+// Flush 把新记录的 key 追加到文件里并同步到磁盘
+func (c *FileCheckpoint) Flush() error {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    if len(c.pending) == 0 {
+        return nil
+    }
+
+    var buf bytes.Buffer
+    for _, key := range c.pending {
+        // key 里可能有换行符, 转义后再写
+        buf.WriteString(strconv.Quote(key))
+        buf.WriteByte('\n')
+    }
+    if _, err := c.file.Write(buf.Bytes()); err != nil {
+        return err
+    }
+    if err := c.file.Sync(); err != nil {
+        return err
+    }
+    c.pending = c.pending[:0]
+    return nil
+}
+
+// Close 写入还没保存的 key 后关闭文件
+func (c *FileCheckpoint) Close() error {
+    err := c.Flush()
+    if closeErr := c.file.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+// checkpointer WithCheckpoint 的参数
+type checkpointer struct {
+    checkpoint Checkpoint
+    key        func(item interface{}) string
+}
+
+func (c *checkpointer) itemKey(item interface{}) string {
+    if c.key != nil {
+        return c.key(item)
+    }
+    return fmt.Sprint(item)
+}
+
+// checkpointMapper 跳过检查点里已经处理完的数据, mapper 成功执行完后由 mapStage 记录数据的 key
+// 要加在最外层, 跳过的数据不用等待限流, 重试成功后才算处理完
+// MapReduceOrdered 等方法在外面还包了一层, 那一层写入结果后才能记录, 所以这里只设置 itemState.mark
+func checkpointMapper[T, U any](mapper MapperFuncOf[T, U], c *checkpointer) MapperFuncOf[T, U] {
+    return func(ctx context.Context, item T, writer WriterOf[U], cancel func(err error)) {
+        key := c.itemKey(item)
+        if c.checkpoint.Done(key) {
+            return
+        }
+
+        var failed int32
+        mapper(ctx, item, writer, func(err error) {
+            atomic.StoreInt32(&failed, 1)
+            cancel(err)
+        })
+        // 任务结束后 mapper 的结果可能没有写进去, 下次要重新处理
+        if atomic.LoadInt32(&failed) == 1 || ctx.Err() != nil {
+            return
+        }
+        state := itemStateFromContext(ctx)
+        if state == nil {
+            if err := c.checkpoint.Mark(key); err != nil {
+                cancel(err)
+            }
+            return
+        }
+        if state.skipped() {
+            return
+        }
+        state.mark = func() error {
+            return c.checkpoint.Mark(key)
+        }
+    }
+}
+
+// flushCheckpoint 每隔 interval 把检查点写入一次, 返回的方法停止定时写入并做最后一次写入
+// 写入失败时停止任务; 任务出错后不再写入, 记录了的数据的结果不一定被 reducer 用上了, 下次要重新处理
+// 任务出错后 runJob 不等 mapper 结束就返回, 定时写入也跟着停止,
+// 不然没有监听 ctx 的 mapper 不退出, 这个协程也一直不退出
+func flushCheckpoint(j *job, c *checkpointer, interval time.Duration) (stop func()) {
+    flush := func() {
+        if j.err() != nil {
+            return
+        }
+        if err := c.checkpoint.Flush(); err != nil {
+            j.cancel(err)
+        }
+    }
+    if interval <= 0 {
+        return flush
+    }
+
+    quit := make(chan struct{})
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-quit:
+                return
+            case <-j.failed:
+                return
+            case <-ticker.C:
+                flush()
+            }
+        }
+    }()
+
+    return func() {
+        close(quit)
+        <-stopped
+        flush()
+    }
+}
@@ -0,0 +1,193 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestMapReduceWithCheckpoint(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "checkpoint")
+    run := func(fail bool) []int {
+        checkpoint, err := OpenFileCheckpoint(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer checkpoint.Close()
+
+        var (
+            lock sync.Mutex
+            seen []int
+        )
+        _, err = MapReduceOf(context.Background(), generateInts(10), func(ctx context.Context, item int,
+            writer WriterOf[int], cancel func(err error)) {
+            lock.Lock()
+            seen = append(seen, item)
+            lock.Unlock()
+            if fail && item == 5 {
+                cancel(errors.New("bad item"))
+                return
+            }
+            writer.Writer(item)
+        }, countInts, WithContinueOnError(), WithCheckpoint(checkpoint, nil), WithCheckpointInterval(0))
+        if fail && err == nil {
+            t.Fatal("want error")
+        }
+        if !fail && err != nil {
+            t.Fatal(err)
+        }
+        sort.Ints(seen)
+        return seen
+    }
+
+    if seen := run(true); len(seen) != 10 {
+        t.Fatalf("want all items processed, got %v", seen)
+    }
+    if seen := run(false); len(seen) != 1 || seen[0] != 5 {
+        t.Fatalf("want only the failed item processed again, got %v", seen)
+    }
+    if seen := run(false); len(seen) != 0 {
+        t.Fatalf("want nothing processed, got %v", seen)
+    }
+}
+
+func TestOpenFileCheckpointTruncated(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "checkpoint")
+    if err := os.WriteFile(path, []byte("\"a\"\n\"b\"\n\"c"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    checkpoint, err := OpenFileCheckpoint(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !checkpoint.Done("a") || !checkpoint.Done("b") || checkpoint.Done("c") {
+        t.Fatal("unexpected keys loaded")
+    }
+    if err := checkpoint.Mark("c\nd"); err != nil {
+        t.Fatal(err)
+    }
+    if err := checkpoint.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    checkpoint, err = OpenFileCheckpoint(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer checkpoint.Close()
+    if !checkpoint.Done("c\nd") || checkpoint.Done("c") {
+        t.Fatal("unexpected keys after reopen")
+    }
+}
+
+type countingCheckpoint struct {
+    lock    sync.Mutex
+    done    map[string]bool
+    flushes int
+}
+
+func (c *countingCheckpoint) Done(key string) bool {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    return c.done[key]
+}
+
+func (c *countingCheckpoint) Mark(key string) error {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    c.done[key] = true
+    return nil
+}
+
+func (c *countingCheckpoint) Flush() error {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    c.flushes++
+    return nil
+}
+
+func TestMapReduceCheckpointIntervalOrder(t *testing.T) {
+    checkpoint := &countingCheckpoint{done: make(map[string]bool)}
+    // WithCheckpointInterval 放在 WithCheckpoint 前面也要生效
+    _, err := MapReduceOf(context.Background(), generateInts(20), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        time.Sleep(2 * time.Millisecond)
+        writer.Writer(item)
+    }, countInts, WithWorkers(1), WithCheckpointInterval(5*time.Millisecond), WithCheckpoint(checkpoint, nil))
+    if err != nil {
+        t.Fatal(err)
+    }
+    checkpoint.lock.Lock()
+    defer checkpoint.lock.Unlock()
+    if checkpoint.flushes < 2 || len(checkpoint.done) != 20 {
+        t.Fatalf("want periodic flushes and 20 keys, got %d flushes and %d keys", checkpoint.flushes, len(checkpoint.done))
+    }
+}
+
+func TestMapReduceCheckpointJobTimeout(t *testing.T) {
+    checkpoint := &countingCheckpoint{done: make(map[string]bool)}
+    block := make(chan struct{})
+    defer close(block)
+    errc := make(chan error, 1)
+    go func() {
+        _, err := MapReduceOf(context.Background(), generateInts(3), func(ctx context.Context, item int,
+            writer WriterOf[int], cancel func(err error)) {
+            if item == 1 {
+                // 不监听 ctx 的 mapper
+                <-block
+            }
+            writer.Writer(item)
+        }, countInts, WithCheckpoint(checkpoint, nil), WithJobTimeout(20*time.Millisecond))
+        errc <- err
+    }()
+    select {
+    case err := <-errc:
+        if !errors.Is(err, ErrTimeout) {
+            t.Fatalf("want %v, got %v", ErrTimeout, err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("blocked mapper kept the job from returning")
+    }
+}
+
+func TestMapReduceCheckpointReducerReturnsEarly(t *testing.T) {
+    for _, fail := range []bool{false, true} {
+        checkpoint := &countingCheckpoint{done: make(map[string]bool)}
+        var reduced int
+        _, err := MapReduceOf(context.Background(), generateInts(100), hangOn(-1), func(ctx context.Context,
+            pipe <-chan int, writer WriterOf[int], cancel func(err error)) {
+            for range pipe {
+                if reduced++; reduced == 20 {
+                    break
+                }
+                // 让 mapper 把缓冲写满
+                time.Sleep(time.Millisecond)
+            }
+            if fail {
+                cancel(errors.New("dummy"))
+                return
+            }
+            writer.Writer(reduced)
+        }, WithResultBuffer(64), WithCheckpoint(checkpoint, nil), WithCheckpointInterval(0))
+        if fail != (err != nil) {
+            t.Fatalf("fail %v: unexpected error %v", fail, err)
+        }
+        // 出错时任务不等 mapper 阶段结束就返回, 等一下看最后有没有写入
+        time.Sleep(20 * time.Millisecond)
+        checkpoint.lock.Lock()
+        marked, flushes := len(checkpoint.done), checkpoint.flushes
+        checkpoint.lock.Unlock()
+        // 缓冲里没被 reducer 读走的结果不算处理完
+        if marked > reduced {
+            t.Fatalf("fail %v: %d items marked, only %d reduced", fail, marked, reduced)
+        }
+        if fail && flushes != 0 {
+            t.Fatalf("want no flush after the job failed, got %d", flushes)
+        }
+    }
+}
@@ -0,0 +1,114 @@
+package mr
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// 带 combiner 的 MapReduce, 和 Hadoop 的 combiner 一样
+// mapper 的结果先在本地合并成部分结果, 只有部分结果才会交给 reducer, 大大减少写入结果管道的次数
+// 适合计数、求和这类可以分步合并的任务
+
+// CombinerFuncOf 把 mapper 的一个结果合并到部分结果 acc 里, 返回新的部分结果
+// acc 一开始是 A 的零值, 比如 map 要自己判断 nil 后创建
+type CombinerFuncOf[U, A any] func(acc A, val U) A
+
+// partial 一个部分结果, 同一时间只会被一个 mapper 使用
+type partial[A any] struct {
+    acc   A
+    count int
+    last  time.Time
+}
+
+// partialPool 保存空闲的部分结果, 个数不会超过同时运行的 mapper 数
+type partialPool[A any] struct {
+    lock sync.Mutex
+    free []*partial[A]
+}
+
+func (p *partialPool[A]) get() *partial[A] {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    if n := len(p.free); n > 0 {
+        res := p.free[n-1]
+        p.free = p.free[:n-1]
+        return res
+    }
+    return &partial[A]{last: time.Now()}
+}
+
+func (p *partialPool[A]) put(res *partial[A]) {
+    p.lock.Lock()
+    p.free = append(p.free, res)
+    p.lock.Unlock()
+}
+
+// flushAll 拿走所有还有数据的部分结果, 只能在所有 mapper 结束后调用
+func (p *partialPool[A]) flushAll() []A {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    var res []A
+    for _, part := range p.free {
+        if part.count > 0 {
+            res = append(res, part.acc)
+        }
+    }
+    p.free = nil
+    return res
+}
+
+type combineWriter[U, A any] struct {
+    part    *partial[A]
+    combine CombinerFuncOf[U, A]
+}
+
+func (w combineWriter[U, A]) Writer(val U) {
+    w.part.acc = w.combine(w.part.acc, val)
+    w.part.count++
+}
+
+// MapReduceCombine 和 MapReduceOf 一样, 只是 mapper 的结果先用 combiner 合并, reducer 收到的是部分结果
+// 默认所有 mapper 结束后才把部分结果交给 reducer, 可以通过 WithCombineFlush 让它提前交出去
+// mapper 必须在返回前写完结果
+func MapReduceCombine[T, U, A, V any](ctx context.Context, generate GenerateFuncOf[T], mapper MapperFuncOf[T, U],
+    combiner CombinerFuncOf[U, A], reducer ReducerFuncOf[A, V], opts ...Option) (V, error) {
+    options := buildOptions(opts...)
+    mapper = decorateMapper(mapper, options)
+
+    return runJob(ctx, options, func(j *job) <-chan A {
+        source := make(chan T, options.sourceBuffer)
+        buildSource(j.ctx, generate, source, j.cancel)
+
+        var pool partialPool[A]
+        partials := mapStage(j, source, func(ctx context.Context, item T, writer WriterOf[A], cancel func(err error)) {
+            part := pool.get()
+            defer pool.put(part)
+
+            mapper(ctx, item, combineWriter[U, A]{part: part, combine: combiner}, cancel)
+            if part.count == 0 {
+                return
+            }
+            if (options.combineItems > 0 && part.count >= options.combineItems) ||
+                (options.combineInterval > 0 && time.Since(part.last) >= options.combineInterval) {
+                writer.Writer(part.acc)
+                *part = partial[A]{last: time.Now()}
+            }
+        }, options)
+
+        // mapper 都结束后把剩下的部分结果交出去
+        resChan := make(chan A, options.resultBuffer)
+        go func() {
+            defer close(resChan)
+            writer := newWriteChan(resChan, j.done)
+            for acc := range partials {
+                writer.Writer(acc)
+            }
+            for _, acc := range pool.flushAll() {
+                writer.Writer(acc)
+            }
+        }()
+
+        return resChan
+    }, reducer)
+}
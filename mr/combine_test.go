@@ -0,0 +1,49 @@
+package mr
+
+import (
+    "context"
+    "testing"
+)
+
+type sumCount struct {
+    sum, partials int
+}
+
+func sumPartials(ctx context.Context, pipe <-chan int, writer WriterOf[sumCount], cancel func(err error)) {
+    var res sumCount
+    for v := range pipe {
+        res.sum += v
+        res.partials++
+    }
+    writer.Writer(res)
+}
+
+func TestMapReduceCombine(t *testing.T) {
+    res, err := MapReduceCombine(context.Background(), generateInts(1000), hangOn(-1), func(acc, val int) int {
+        return acc + val
+    }, sumPartials, WithWorkers(4))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res.sum != 499500 {
+        t.Fatalf("want 499500, got %d", res.sum)
+    }
+    if res.partials > 4 {
+        t.Fatalf("want at most 4 partials, got %d", res.partials)
+    }
+}
+
+func TestMapReduceCombineFlush(t *testing.T) {
+    res, err := MapReduceCombine(context.Background(), generateInts(1000), hangOn(-1), func(acc, val int) int {
+        return acc + val
+    }, sumPartials, WithWorkers(4), WithCombineFlush(10, 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res.sum != 499500 {
+        t.Fatalf("want 499500, got %d", res.sum)
+    }
+    if res.partials < 100 || res.partials > 104 {
+        t.Fatalf("want about 100 partials, got %d", res.partials)
+    }
+}
@@ -0,0 +1,131 @@
+package mr
+
+import (
+    "context"
+    "encoding/json"
+    "io"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// 死信: 处理失败或者被跳过的数据不再停止任务, 而是交给 DeadLetterSink 保存起来, 以后可以重新处理
+
+// DeadLetter 一个处理失败或者被跳过的数据
+type DeadLetter struct {
+    Item     interface{}
+    Err      error
+    // 一共执行了几次, 开启重试时包含重试的次数
+    Attempts int
+    Time     time.Time
+}
+
+// DeadLetterSink 保存死信, Put 返回错误时整个任务会停止, 避免数据丢失
+// Put 会被多个 mapper 协程同时调用, 要并发安全
+type DeadLetterSink interface {
+    Put(letter DeadLetter) error
+}
+
+// DeadLetterFunc 把方法当作 DeadLetterSink 使用
+type DeadLetterFunc func(letter DeadLetter) error
+
+func (f DeadLetterFunc) Put(letter DeadLetter) error {
+    return f(letter)
+}
+
+// DeadLetterChan 把死信写到 ch 里, ch 满了会阻塞 mapper, ctx 被取消时返回 ctx.Err()
+func DeadLetterChan(ctx context.Context, ch chan<- DeadLetter) DeadLetterSink {
+    return DeadLetterFunc(func(letter DeadLetter) error {
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case ch <- letter:
+            return nil
+        }
+    })
+}
+
+// jsonLinesSink 每个死信写一行 json
+type jsonLinesSink struct {
+    lock    sync.Mutex
+    encoder *json.Encoder
+}
+
+type jsonDeadLetter struct {
+    Item     interface{} `json:"item"`
+    Err      string      `json:"error"`
+    Attempts int         `json:"attempts"`
+    Time     time.Time   `json:"time"`
+}
+
+// NewJSONLinesSink 把死信按 json lines 格式写到 w 里, 一行一个:
+// {"item":...,"error":"...","attempts":1,"time":"..."}
+// 数据不能转成 json 时返回错误, 任务会停止
+func NewJSONLinesSink(w io.Writer) DeadLetterSink {
+    return &jsonLinesSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *jsonLinesSink) Put(letter DeadLetter) error {
+    var errMsg string
+    if letter.Err != nil {
+        errMsg = letter.Err.Error()
+    }
+
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return s.encoder.Encode(jsonDeadLetter{
+        Item:     letter.Item,
+        Err:      errMsg,
+        Attempts: letter.Attempts,
+        Time:     letter.Time,
+    })
+}
+
+type itemStateKey struct{}
+
+// itemState 处理一个数据时的状态, 通过 ctx 传给在 mapper 外层实现的重试、超时
+type itemState struct {
+    attempts int32
+    // 数据是不是被跳过了
+    skip     int32
+    // 数据被跳过时调用, 没有开启死信时是 nil
+    onSkip   func(err error)
+    // 开启检查点时 mapper 成功执行完后设置, 结果都交出去以后调用, 记录数据已经处理完了
+    mark     func() error
+}
+
+func itemStateFromContext(ctx context.Context) *itemState {
+    state, _ := ctx.Value(itemStateKey{}).(*itemState)
+    return state
+}
+
+func (s *itemState) setAttempt(attempt int) {
+    atomic.StoreInt32(&s.attempts, int32(attempt))
+}
+
+// attempt s 为 nil 时说明没有记录, 按 1 次算
+func (s *itemState) attempt() int {
+    if s == nil {
+        return 1
+    }
+    if attempt := atomic.LoadInt32(&s.attempts); attempt > 0 {
+        return int(attempt)
+    }
+    return 1
+}
+
+func (s *itemState) skipped() bool {
+    return atomic.LoadInt32(&s.skip) == 1
+}
+
+// skipItem 超时等原因跳过数据时调用, 开启死信时会写入死信
+func skipItem(ctx context.Context, err error) {
+    state := itemStateFromContext(ctx)
+    if state == nil {
+        return
+    }
+    atomic.StoreInt32(&state.skip, 1)
+    if state.onSkip != nil {
+        state.onSkip(err)
+    }
+}
@@ -0,0 +1,76 @@
+package mr
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestMapReduceWithDeadLetter(t *testing.T) {
+    var buf bytes.Buffer
+    res, err := MapReduceOf(context.Background(), generateInts(10), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        if item == 3 {
+            cancel(errors.New("bad item"))
+            return
+        }
+        writer.Writer(item)
+    }, countInts, WithDeadLetter(NewJSONLinesSink(&buf)), WithRetry(RetryPolicy{MaxAttempts: 2}))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res != 9 {
+        t.Fatalf("want 9, got %d", res)
+    }
+
+    var letter struct {
+        Item     int    `json:"item"`
+        Err      string `json:"error"`
+        Attempts int    `json:"attempts"`
+    }
+    if err := json.Unmarshal(buf.Bytes(), &letter); err != nil {
+        t.Fatal(err)
+    }
+    if letter.Item != 3 || letter.Err != "bad item" || letter.Attempts != 2 {
+        t.Fatalf("unexpected dead letter: %s", buf.String())
+    }
+}
+
+func TestMapOrderedWithDeadLetterSkip(t *testing.T) {
+    letters := make(chan DeadLetter, 10)
+    res, err := MapOrdered(context.Background(), generateInts(10), hangOn(5),
+        WithItemTimeout(10*time.Millisecond), WithTimeoutPolicy(TimeoutSkip),
+        WithDeadLetter(DeadLetterChan(context.Background(), letters)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res) != 9 {
+        t.Fatalf("want 9 results, got %v", res)
+    }
+    close(letters)
+    var got []DeadLetter
+    for letter := range letters {
+        got = append(got, letter)
+    }
+    if len(got) != 1 || got[0].Item != 5 || got[0].Err != ErrItemTimeout {
+        t.Fatalf("unexpected dead letters: %+v", got)
+    }
+}
+
+func TestMapReduceDeadLetterSinkError(t *testing.T) {
+    _, err := MapReduceOf(context.Background(), generateInts(10), func(ctx context.Context, item int,
+        writer WriterOf[func()], cancel func(err error)) {
+        cancel(errors.New("bad item"))
+    }, func(ctx context.Context, pipe <-chan func(), writer WriterOf[int], cancel func(err error)) {
+        drain(pipe)
+    }, WithDeadLetter(DeadLetterFunc(func(letter DeadLetter) error {
+        return errors.New("sink is full")
+    })))
+    if err == nil || !strings.Contains(err.Error(), "sink is full") {
+        t.Fatalf("want sink error, got %v", err)
+    }
+}
@@ -0,0 +1,86 @@
+package mr
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+)
+
+// ItemError 表示处理某个数据时出错了
+type ItemError struct {
+    // 出错的数据
+    Item interface{}
+    Err  error
+}
+
+func (e *ItemError) Error() string {
+    return fmt.Sprintf("item %v: %v", e.Item, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+    return e.Err
+}
+
+// MultiError 在 WithContinueOnError 模式下返回, 包含所有出错的数据
+// errors.Is/errors.As 会依次检查里面的每一个错误
+type MultiError struct {
+    // 处理数据出错的是 *ItemError, generate、reducer 出错或者任务被取消是原始的错误
+    Errors []error
+}
+
+func (m *MultiError) Error() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "%d errors occurred:", len(m.Errors))
+    for _, err := range m.Errors {
+        b.WriteString("\n\t* ")
+        b.WriteString(err.Error())
+    }
+    return b.String()
+}
+
+func (m *MultiError) Is(target error) bool {
+    for _, err := range m.Errors {
+        if errors.Is(err, target) {
+            return true
+        }
+    }
+    return false
+}
+
+func (m *MultiError) As(target interface{}) bool {
+    for _, err := range m.Errors {
+        if errors.As(err, target) {
+            return true
+        }
+    }
+    return false
+}
+
+// errorCollector 收集每个数据的错误
+type errorCollector struct {
+    lock sync.Mutex
+    errs []error
+}
+
+func (c *errorCollector) add(item interface{}, err error) {
+    c.lock.Lock()
+    c.errs = append(c.errs, &ItemError{Item: item, Err: err})
+    c.lock.Unlock()
+}
+
+// result 把收集到的错误和任务本身的错误合并, 都没有时返回 nil
+func (c *errorCollector) result(err error) error {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    if len(c.errs) == 0 {
+        return err
+    }
+
+    errs := make([]error, len(c.errs), len(c.errs)+1)
+    copy(errs, c.errs)
+    if err != nil {
+        errs = append(errs, err)
+    }
+    return &MultiError{Errors: errs}
+}
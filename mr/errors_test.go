@@ -0,0 +1,115 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestFinishAll(t *testing.T) {
+    err1 := errors.New("err1")
+    err2 := errors.New("err2")
+    var ran int32
+    err := FinishAll(func() error {
+        atomic.AddInt32(&ran, 1)
+        return err1
+    }, func() error {
+        atomic.AddInt32(&ran, 1)
+        return nil
+    }, func() error {
+        atomic.AddInt32(&ran, 1)
+        return err2
+    })
+    if ran != 3 {
+        t.Fatalf("want 3 functions run, got %d", ran)
+    }
+    var me *MultiError
+    if !errors.As(err, &me) || len(me.Errors) != 2 {
+        t.Fatalf("want *MultiError with 2 errors, got %v", err)
+    }
+    if !errors.Is(err, err1) || !errors.Is(err, err2) {
+        t.Fatalf("want both errors matched, got %v", err)
+    }
+    var ie *ItemError
+    if !errors.As(err, &ie) || (ie.Item != 0 && ie.Item != 2) {
+        t.Fatalf("want *ItemError for item 0 or 2, got %v", err)
+    }
+}
+
+func TestMapReduceContinueOnError(t *testing.T) {
+    res, err := MapReduceOf(context.Background(), func(ctx context.Context, source chan<- int, cancel func(err error)) {
+        for i := 0; i < 10; i++ {
+            source <- i
+        }
+    }, func(ctx context.Context, item int, writer WriterOf[int], cancel func(err error)) {
+        if item == 3 {
+            panic("boom")
+        }
+        writer.Writer(item)
+    }, func(ctx context.Context, pipe <-chan int, writer WriterOf[int], cancel func(err error)) {
+        var n int
+        for range pipe {
+            n++
+        }
+        writer.Writer(n)
+    }, WithContinueOnError())
+    var pe *PanicError
+    if !errors.As(err, &pe) {
+        t.Fatalf("want *PanicError, got %v", err)
+    }
+    if res != 9 {
+        t.Fatalf("want results of the other 9 items, got %d", res)
+    }
+}
+
+func TestMapReduceContinueOnErrorCanceled(t *testing.T) {
+    errDummy := errors.New("dummy")
+    run := func(ctx context.Context, block <-chan struct{}, opts ...Option) error {
+        _, err := MapReduceOf(ctx, generateInts(3), func(ctx context.Context, item int, writer WriterOf[int],
+            cancel func(err error)) {
+            switch item {
+            case 0:
+                cancel(errDummy)
+            case 1:
+                // 不监听 ctx 的 mapper
+                <-block
+            default:
+                writer.Writer(item)
+            }
+        }, countInts, append(opts, WithContinueOnError())...)
+        return err
+    }
+    tests := map[string]struct {
+        opts   []Option
+        cancel time.Duration
+        want   error
+    }{
+        "job timeout": {opts: []Option{WithJobTimeout(20 * time.Millisecond)}, want: ErrTimeout},
+        "parent ctx":  {cancel: 20 * time.Millisecond, want: context.Canceled},
+    }
+    for name, test := range tests {
+        t.Run(name, func(t *testing.T) {
+            block := make(chan struct{})
+            defer close(block)
+            ctx, cancel := context.WithCancel(context.Background())
+            defer cancel()
+            if test.cancel > 0 {
+                time.AfterFunc(test.cancel, cancel)
+            }
+            errc := make(chan error, 1)
+            go func() {
+                errc <- run(ctx, block, test.opts...)
+            }()
+            select {
+            case err := <-errc:
+                if !errors.Is(err, test.want) || !errors.Is(err, errDummy) {
+                    t.Fatalf("want %v and %v, got %v", test.want, errDummy, err)
+                }
+            case <-time.After(time.Second):
+                t.Fatal("blocked mapper kept the job from returning")
+            }
+        })
+    }
+}
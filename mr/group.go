@@ -0,0 +1,118 @@
+package mr
+
+import (
+    "context"
+    "sync"
+)
+
+// Group 和 errgroup.Group 一样并发执行一组方法, 和 Finish 不同的是方法可以随时添加
+// 第一个出错或者 panic 的方法会取消传给其它方法的 ctx, Wait 返回这个错误, panic 会转成 *PanicError
+// 零值可以直接使用, 这时 ctx 是 context.Background()
+type Group struct {
+    initOnce sync.Once
+    ctx      context.Context
+    cancel   context.CancelFunc
+    wg       sync.WaitGroup
+
+    lock     sync.Mutex
+    // 有方法退出或者上限变大时通知等待的 Go
+    cond     *sync.Cond
+    // 同时运行的方法数上限, 小于 0 表示不限制
+    limit    int
+    active   int
+
+    errOnce  sync.Once
+    err      error
+}
+
+// NewGroup 创建 Group, 传给方法的 ctx 派生自 ctx, 有方法出错或者 Wait 返回时被取消
+func NewGroup(ctx context.Context) *Group {
+    g := new(Group)
+    g.initWith(ctx)
+    return g
+}
+
+func (g *Group) init() {
+    g.initWith(context.Background())
+}
+
+func (g *Group) initWith(ctx context.Context) {
+    g.initOnce.Do(func() {
+        g.ctx, g.cancel = context.WithCancel(ctx)
+        g.cond = sync.NewCond(&g.lock)
+        g.limit = -1
+    })
+}
+
+// SetLimit 设置同时运行的方法数上限, n 小于 0 表示不限制
+// 上限变小时已经在运行的方法不受影响, 等它们退出后才会降下来
+func (g *Group) SetLimit(n int) {
+    g.init()
+    g.lock.Lock()
+    g.limit = n
+    g.lock.Unlock()
+    g.cond.Broadcast()
+}
+
+// Go 在新的协程里执行 fn, 达到 SetLimit 设置的上限时阻塞, 直到有方法退出
+func (g *Group) Go(fn func(ctx context.Context) error) {
+    g.init()
+    g.lock.Lock()
+    for g.limit >= 0 && g.active >= g.limit {
+        g.cond.Wait()
+    }
+    g.active++
+    g.lock.Unlock()
+
+    g.start(fn)
+}
+
+// TryGo 没有达到上限时和 Go 一样执行 fn 并返回 true, 否则不执行, 返回 false
+func (g *Group) TryGo(fn func(ctx context.Context) error) bool {
+    g.init()
+    g.lock.Lock()
+    if g.limit >= 0 && g.active >= g.limit {
+        g.lock.Unlock()
+        return false
+    }
+    g.active++
+    g.lock.Unlock()
+
+    g.start(fn)
+    return true
+}
+
+func (g *Group) start(fn func(ctx context.Context) error) {
+    g.wg.Add(1)
+    go func() {
+        defer g.done()
+        defer recoverToCancel(g.fail)
+        if err := fn(g.ctx); err != nil {
+            g.fail(err)
+        }
+    }()
+}
+
+func (g *Group) done() {
+    g.lock.Lock()
+    g.active--
+    g.lock.Unlock()
+    g.cond.Signal()
+    g.wg.Done()
+}
+
+// fail 只保存第一个错误, 并取消其它方法的 ctx
+func (g *Group) fail(err error) {
+    g.errOnce.Do(func() {
+        g.err = err
+        g.cancel()
+    })
+}
+
+// Wait 等待所有方法执行完, 返回第一个错误
+func (g *Group) Wait() error {
+    g.init()
+    g.wg.Wait()
+    g.cancel()
+    return g.err
+}
@@ -0,0 +1,98 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestGroup(t *testing.T) {
+    var (
+        g                   Group
+        running, maxRunning int32
+        sum                 int32
+    )
+    g.SetLimit(3)
+    for i := 1; i <= 10; i++ {
+        i := i
+        g.Go(func(ctx context.Context) error {
+            n := atomic.AddInt32(&running, 1)
+            for {
+                m := atomic.LoadInt32(&maxRunning)
+                if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+                    break
+                }
+            }
+            time.Sleep(5 * time.Millisecond)
+            atomic.AddInt32(&running, -1)
+            atomic.AddInt32(&sum, int32(i))
+            return nil
+        })
+    }
+    if err := g.Wait(); err != nil {
+        t.Fatal(err)
+    }
+    if sum != 55 {
+        t.Fatalf("want 55, got %d", sum)
+    }
+    if maxRunning > 3 {
+        t.Fatalf("want at most 3 running, got %d", maxRunning)
+    }
+}
+
+func TestGroupError(t *testing.T) {
+    errDummy := errors.New("dummy")
+    g := NewGroup(context.Background())
+    g.Go(func(ctx context.Context) error {
+        <-ctx.Done()
+        return ctx.Err()
+    })
+    g.Go(func(ctx context.Context) error {
+        return errDummy
+    })
+    if err := g.Wait(); err != errDummy {
+        t.Fatalf("want %v, got %v", errDummy, err)
+    }
+}
+
+func TestGroupPanic(t *testing.T) {
+    g := NewGroup(context.Background())
+    g.Go(func(ctx context.Context) error {
+        panic("group")
+    })
+    var pe *PanicError
+    if err := g.Wait(); !errors.As(err, &pe) || pe.Value != "group" {
+        t.Fatalf("want *PanicError, got %v", err)
+    }
+}
+
+func TestGroupTryGo(t *testing.T) {
+    g := NewGroup(context.Background())
+    g.SetLimit(1)
+    release := make(chan struct{})
+    if !g.TryGo(func(ctx context.Context) error {
+        <-release
+        return nil
+    }) {
+        t.Fatal("want the first TryGo to run")
+    }
+    if g.TryGo(func(ctx context.Context) error {
+        return nil
+    }) {
+        t.Fatal("want TryGo to fail when the limit is reached")
+    }
+    close(release)
+    if err := g.Wait(); err != nil {
+        t.Fatal(err)
+    }
+    if !g.TryGo(func(ctx context.Context) error {
+        return nil
+    }) {
+        t.Fatal("want TryGo to run after the others finished")
+    }
+    if err := g.Wait(); err != nil {
+        t.Fatal(err)
+    }
+}
@@ -0,0 +1,383 @@
+package mr
+
+import (
+    "context"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// job 保存一次任务运行时的状态, 和数据类型无关, MapReduceOf 和 Pipeline 的各个阶段都用它
+type job struct {
+    // 传给所有用户方法的 ctx, 任务结束时被取消
+    ctx     context.Context
+    // 如果有任何异常都把这个关闭了，让其他的接收到通知，停止运行
+    done    chan struct{}
+    options *mapReduceOptions
+
+    ctxCancel context.CancelFunc
+    doneOnce  sync.Once
+    errOnce   sync.Once
+    // 原子存储错误, 避免资源竞争
+    errVal    atomic.Value
+    // 保存第一个错误时关闭
+    failed    chan struct{}
+    // WithContinueOnError 模式下收集每个数据的错误
+    itemErrs  errorCollector
+    // 等待所有 mapper 阶段结束
+    stages    sync.WaitGroup
+    // 已经启动的 mapper 阶段数
+    stageNum  int32
+    // 为 1 时任务返回前要等所有 mapper 阶段结束, 比如要写入检查点
+    waitStages int32
+    // 开启了 WithStats 或者 WithObserver, 要统计每个数据
+    tracking  bool
+    start     time.Time
+    stats     jobStats
+}
+
+func newJob(ctx context.Context, options *mapReduceOptions) *job {
+    j := &job{
+        done:     make(chan struct{}),
+        failed:   make(chan struct{}),
+        options:  options,
+        tracking: options.stats != nil || options.observer != nil || options.progress != nil,
+        start:    time.Now(),
+    }
+    if j.tracking {
+        // 重试等在 mapper 外层实现的功能通过 ctx 找到统计数据
+        ctx = context.WithValue(ctx, statsKey{}, &j.stats)
+    }
+    j.ctx, j.ctxCancel = context.WithCancel(ctx)
+
+    return j
+}
+
+// finish 执行一次关闭, 通知所有协程退出, 返回是不是这次调用关闭的
+func (j *job) finish() bool {
+    var closed bool
+    j.doneOnce.Do(func() {
+        // 先取消 ctx 再关闭 done, 写入因为 done 关闭被丢掉时 ctx.Err() 一定不是 nil, 检查点靠这个判断结果有没有交出去
+        j.ctxCancel()
+        close(j.done)
+        closed = true
+    })
+    return closed
+}
+
+// storeErr 只保存第一个错误, 后面的错误大多是被第一个错误连带出来的
+// 保存第一个错误时通知 OnJobCancel, reducer 可能先于 cancel 关闭 done, 不能按谁关闭了 done 判断
+// 在 once 里通知, 同时保存错误的其它调用要等通知完才返回
+func (j *job) storeErr(err error) {
+    j.errOnce.Do(func() {
+        if err == nil {
+            err = cancelWithNil
+        }
+        j.errVal.Store(err)
+        close(j.failed)
+        if j.options.observer != nil {
+            j.options.observer.OnJobCancel(err)
+        }
+    })
+}
+
+// cancel 传给用户方法, 保存错误并停止任务
+func (j *job) cancel(err error) {
+    j.storeErr(err)
+    j.finish()
+}
+
+func (j *job) err() error {
+    if err := j.errVal.Load(); err != nil {
+        return err.(error)
+    }
+    return nil
+}
+
+// awaitStages 等所有 mapper 阶段结束, 任务出错时不再等待
+// 超时、ctx 被取消后还不退出的 mapper 不能让任务一直不返回
+func (j *job) awaitStages() {
+    stopped := make(chan struct{})
+    go func() {
+        j.stages.Wait()
+        close(stopped)
+    }()
+    select {
+    case <-stopped:
+    case <-j.failed:
+    }
+}
+
+// itemCancel 返回处理 item 时用的 cancel, state 在没有开启死信时是 nil
+func (j *job) itemCancel(item interface{}, state *itemState) func(err error) {
+    if !j.options.continueOnError && j.options.deadLetter == nil {
+        return j.cancel
+    }
+    // 只记录这个数据的错误, 不停止任务; cancel(nil) 还是表示停止任务
+    return func(err error) {
+        if err == nil {
+            j.cancel(nil)
+            return
+        }
+        if j.options.continueOnError {
+            j.itemErrs.add(item, err)
+        }
+        j.putDeadLetter(item, err, state)
+    }
+}
+
+// putDeadLetter 把出错或者被跳过的数据写入死信, 写入失败时停止任务
+func (j *job) putDeadLetter(item interface{}, err error, state *itemState) {
+    if j.options.deadLetter == nil {
+        return
+    }
+    if putErr := j.options.deadLetter.Put(DeadLetter{
+        Item:     item,
+        Err:      err,
+        Attempts: state.attempt(),
+        Time:     time.Now(),
+    }); putErr != nil {
+        j.cancel(putErr)
+    }
+}
+
+// mapStage 启动一个 mapper 阶段, 最多 options.workers 个协程同时处理 source 里的数据,
+// 结果写入返回的管道, 管道的缓冲大小是 options.resultBuffer, 所有 mapper 结束后关闭
+// 开启检查点时管道不带缓冲, 写入返回就说明结果被读走了, 这时才记录数据已经处理完
+func mapStage[T, U any](j *job, source <-chan T, mapper MapperFuncOf[T, U], options *mapReduceOptions) <-chan U {
+    buffer := options.resultBuffer
+    if options.checkpoint != nil {
+        buffer = 0
+    }
+    resChan := make(chan U, buffer)
+    pool := newWorkerPool(options.workers)
+    atomic.StoreInt64(&j.stats.workers, int64(options.workers))
+    var controller *adaptiveController
+    if options.adaptive != nil {
+        controller = newAdaptiveController(*options.adaptive, pool, &j.stats.workers)
+    }
+
+    first := atomic.AddInt32(&j.stageNum, 1) == 1
+    // 最后一次写入检查点要在任务返回前完成
+    var stopFlush func()
+    if options.checkpoint != nil {
+        atomic.StoreInt32(&j.waitStages, 1)
+        stopFlush = flushCheckpoint(j, options.checkpoint, options.checkpointInterval)
+    }
+
+    j.stages.Add(1)
+    go func() {
+        defer j.stages.Done()
+        executeMappers(func(item T, writer WriterOf[U]) {
+            reported := itemValue(item)
+            ctx := j.ctx
+            var state *itemState
+            if j.options.deadLetter != nil || options.checkpoint != nil || j.options.observer != nil {
+                state = new(itemState)
+                if j.options.deadLetter != nil {
+                    state.onSkip = func(err error) {
+                        j.putDeadLetter(reported, err, state)
+                    }
+                }
+                ctx = context.WithValue(ctx, itemStateKey{}, state)
+            }
+            cancel := j.itemCancel(reported, state)
+            if controller != nil || j.tracking {
+                var (
+                    lock    sync.Mutex
+                    failed  bool
+                    itemErr error
+                )
+                itemCancel := cancel
+                cancel = func(err error) {
+                    lock.Lock()
+                    if !failed {
+                        failed = true
+                        itemErr = err
+                    }
+                    lock.Unlock()
+                    itemCancel(err)
+                }
+                j.itemStart(reported, first)
+                start := time.Now()
+                defer func() {
+                    latency := time.Since(start)
+                    lock.Lock()
+                    failed, err := failed, itemErr
+                    lock.Unlock()
+                    if controller != nil {
+                        controller.observe(latency, failed)
+                    }
+                    j.itemFinish(reported, first, latency, state.attempt(), failed, err)
+                }()
+            }
+            // mapper panic 时停止任务, 把 panic 作为错误返回
+            defer recoverToCancel(cancel)
+            mapper(ctx, item, writer, cancel)
+            // 任务结束后写入可能被丢掉了, 不记录
+            if state != nil && state.mark != nil && j.ctx.Err() == nil {
+                if err := state.mark(); err != nil {
+                    cancel(err)
+                }
+            }
+        }, resChan, j.done, source, pool)
+        if stopFlush != nil {
+            stopFlush()
+        }
+        if j.tracking {
+            j.stats.stageDone(time.Since(j.start))
+        }
+    }()
+
+    return resChan
+}
+
+// itemValue 返回报告错误、死信时用的数据, 内部包装过的数据要还原成用户的数据
+func itemValue(item interface{}) interface{} {
+    if wrapped, ok := item.(interface{ unwrapItem() interface{} }); ok {
+        return wrapped.unwrapItem()
+    }
+    return item
+}
+
+func (j *job) itemStart(item interface{}, first bool) {
+    if !j.tracking {
+        return
+    }
+    if first {
+        atomic.AddInt64(&j.stats.generated, 1)
+    }
+    if j.options.observer != nil {
+        j.options.observer.OnItemStart(item)
+    }
+}
+
+func (j *job) itemFinish(item interface{}, first bool, latency time.Duration, attempts int, failed bool,
+    err error) {
+    if !j.tracking {
+        return
+    }
+    j.stats.addLatency(latency)
+    switch {
+    case failed:
+        if err == nil {
+            err = cancelWithNil
+        }
+        atomic.AddInt64(&j.stats.failed, 1)
+        if first {
+            atomic.AddInt64(&j.stats.firstFailed, 1)
+        }
+    case j.ctx.Err() != nil:
+        err = j.ctx.Err()
+        atomic.AddInt64(&j.stats.canceled, 1)
+    default:
+        atomic.AddInt64(&j.stats.mapped, 1)
+        if first {
+            atomic.AddInt64(&j.stats.firstMapped, 1)
+        }
+    }
+    if j.options.observer != nil {
+        j.options.observer.OnItemFinish(item, latency, attempts, err)
+    }
+}
+
+// end 任务返回前汇总统计信息
+func (j *job) end() {
+    if !j.tracking {
+        return
+    }
+    var stats Stats
+    j.stats.fill(&stats)
+    stats.Duration = time.Since(j.start)
+    if j.options.stats != nil {
+        *j.options.stats = stats
+    }
+    if j.options.observer != nil {
+        j.options.observer.OnJobEnd(stats)
+    }
+}
+
+// runJob 运行任务, start 启动 generate 和各个 mapper 阶段, 返回最后一个阶段的结果管道, reducer 从这个管道里读取数据
+func runJob[U, V any](ctx context.Context, options *mapReduceOptions, start func(j *job) <-chan U,
+    reducer ReducerFuncOf[U, V]) (V, error) {
+    parent := ctx
+    j := newJob(ctx, options)
+    defer j.end()
+    if options.progress != nil {
+        // 在 end 之前执行, 最后一次进度在任务返回前报告
+        defer j.reportProgress()()
+    }
+    // 存放处理结果, mapper 往这个里面写入， reduce 从这个里面读出
+    resChan := start(j)
+
+    if options.jobTimeout > 0 {
+        timer := time.AfterFunc(options.jobTimeout, func() {
+            j.cancel(ErrTimeout)
+        })
+        defer timer.Stop()
+    }
+
+    // 外部 ctx 被取消时停止任务, 等 mapper 阶段结束时也要监听, 所以到返回时才退出
+    returned := make(chan struct{})
+    defer close(returned)
+    go func() {
+        select {
+        case <-parent.Done():
+            j.cancel(parent.Err())
+        case <-returned:
+        }
+    }()
+
+    // 创建写入实例 reducer 这个变量把结果汇总给这个chan
+    // 这个管道不关闭, 防止 reducer 正在写入时被关闭导致 panic, 结束与否以 done 为准
+    reduceChan := make(chan V, 1)
+    write := newWriteChan(reduceChan, j.done)
+    // 启动协程 执行结果集归并方法
+    go func() {
+        defer func() {
+            j.finish()
+            drain(resChan)
+        }()
+        // 在这里可能遇到错误就结束运行了, reschan 可能还有数据, 所以要在 defer 中把数据都给读取完
+        defer recoverToCancel(j.cancel)
+        reducer(j.ctx, resChan, write, j.cancel)
+    }()
+
+    // 此时我们应该取出错误 和 结果
+    // reducer 没有写入结果时 res 是零值
+    var res V
+    select {
+    case res = <-reduceChan:
+    case <-j.done:
+        // reducer 写完结果后 done 才会被关闭, 这里再看一下有没有结果
+        select {
+        case res = <-reduceChan:
+        default:
+        }
+    }
+    // 外部 ctx 被取消后, 内部 ctx 也跟着取消了, 各个协程可能先于上面的监听协程退出, 这里要把错误补上
+    if err := parent.Err(); err != nil {
+        j.cancel(err)
+    }
+    // 拿到结果后通知其它协程退出
+    j.finish()
+    // reducer 可能没读完就写了结果, 等 mapper 都结束了再汇总错误
+    // 超时、ctx 被取消时不等, 直接返回已经收集到的错误
+    if options.continueOnError || atomic.LoadInt32(&j.waitStages) == 1 {
+        j.awaitStages()
+    }
+
+    err := j.err()
+    if options.continueOnError {
+        if err == nil {
+            // 只有数据出错时, 其它数据的结果依然有效, 和错误一起返回
+            return res, j.itemErrs.result(nil)
+        }
+        err = j.itemErrs.result(err)
+    }
+    if err != nil {
+        var zero V
+        return zero, err
+    }
+    return res, nil
+}
@@ -0,0 +1,160 @@
+package mr_test
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/wanmei002/goutil/mr"
+    "github.com/wanmei002/goutil/mr/mrtest"
+)
+
+var errDummy = errors.New("dummy")
+
+// endless 一直产生数据, 直到任务结束
+func endless() mr.GenerateFuncOf[int] {
+    var n int
+    return mr.FromFunc(func() (int, bool, error) {
+        n++
+        return n, true, nil
+    })
+}
+
+func echo(ctx context.Context, item int, writer mr.WriterOf[int], cancel func(err error)) {
+    writer.Writer(item)
+}
+
+func failAt(n int) mr.MapperFuncOf[int, int] {
+    return func(ctx context.Context, item int, writer mr.WriterOf[int], cancel func(err error)) {
+        if item == n {
+            cancel(errDummy)
+            return
+        }
+        writer.Writer(item)
+    }
+}
+
+// returnEarly 读一个数据就返回
+func returnEarly[U any](ctx context.Context, pipe <-chan U, writer mr.WriterOf[int], cancel func(err error)) {
+    <-pipe
+    writer.Writer(1)
+}
+
+func drainPipe[U any](ctx context.Context, pipe <-chan U, writer mr.WriterOf[int], cancel func(err error)) {
+    for range pipe {
+    }
+}
+
+func TestNoLeaks(t *testing.T) {
+    tests := map[string]func() error{
+        "reducer returns early": func() error {
+            _, err := mr.MapReduceOf(context.Background(), endless(), echo, returnEarly[int])
+            return err
+        },
+        "reducer cancel": func() error {
+            _, err := mr.MapReduceOf(context.Background(), endless(), echo, func(ctx context.Context,
+                pipe <-chan int, writer mr.WriterOf[int], cancel func(err error)) {
+                <-pipe
+                cancel(errDummy)
+            })
+            return err
+        },
+        "mapper cancel": func() error {
+            _, err := mr.MapReduceOf(context.Background(), endless(), failAt(100), drainPipe[int])
+            return err
+        },
+        "parent cancel": func() error {
+            ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+            defer cancel()
+            _, err := mr.MapReduceOf(ctx, endless(), echo, drainPipe[int])
+            return err
+        },
+        "legacy generate ignores cancel": func() error {
+            _, err := mr.MapReduce(func(source chan<- interface{}) {
+                for i := 0; i < 1000; i++ {
+                    source <- i
+                }
+            }, func(item interface{}, writer mr.Writer, cancel func(err error)) {
+                writer.Writer(item)
+            }, func(pipe <-chan interface{}, writer mr.Writer, cancel func(err error)) {
+                <-pipe
+                writer.Writer(1)
+            })
+            return err
+        },
+        "ordered": func() error {
+            _, err := mr.MapOrdered(context.Background(), endless(), failAt(100), mr.WithReorderWindow(4))
+            return err
+        },
+        "by key": func() error {
+            _, err := mr.MapReduceByKey(context.Background(), endless(), func(ctx context.Context, item int,
+                writer mr.KeyedWriterOf[int, int], cancel func(err error)) {
+                if item == 100 {
+                    cancel(errDummy)
+                }
+                writer.Writer(item%10, item)
+            }, func(ctx context.Context, key int, values []int) (int, error) {
+                return len(values), nil
+            })
+            return err
+        },
+        "combine": func() error {
+            _, err := mr.MapReduceCombine(context.Background(), endless(), failAt(100), func(acc, val int) int {
+                return acc + val
+            }, drainPipe[int], mr.WithCombineFlush(10, 0))
+            return err
+        },
+        "batches": func() error {
+            _, err := mr.MapBatches(context.Background(), endless(), func(ctx context.Context, items []int,
+                writer mr.WriterOf[int], cancel func(err error)) {
+                cancel(errDummy)
+            }, drainPipe[int], mr.WithBatch(10, time.Millisecond))
+            return err
+        },
+        "pipeline": func() error {
+            p := mr.Then(mr.NewPipeline(endless()), echo, mr.WithWorkers(2))
+            p = p.Then(failAt(100))
+            _, err := mr.RunPipeline(context.Background(), p, drainPipe[int])
+            return err
+        },
+        "priority": func() error {
+            var n int
+            _, err := mr.MapReducePriority(context.Background(), func(ctx context.Context,
+                source chan<- mr.Prioritized[int], cancel func(err error)) {
+                for {
+                    n++
+                    select {
+                    case <-ctx.Done():
+                        return
+                    case source <- mr.Prioritized[int]{Item: n, Priority: n % 3}:
+                    }
+                }
+            }, failAt(100), drainPipe[int])
+            return err
+        },
+        "item timeout": func() error {
+            _, err := mr.MapReduceOf(context.Background(), endless(), func(ctx context.Context, item int,
+                writer mr.WriterOf[int], cancel func(err error)) {
+                <-ctx.Done()
+            }, returnEarly[int], mr.WithItemTimeout(time.Millisecond), mr.WithTimeoutPolicy(mr.TimeoutSkip),
+                mr.WithJobTimeout(20*time.Millisecond))
+            return err
+        },
+        "finish": func() error {
+            return mr.FinishWithContext(context.Background(), func(ctx context.Context) error {
+                <-ctx.Done()
+                return nil
+            }, func(ctx context.Context) error {
+                return errDummy
+            })
+        },
+    }
+    for name, fn := range tests {
+        t.Run(name, func(t *testing.T) {
+            defer mrtest.VerifyNoLeaks(t)()
+            // 只关心协程有没有退出, 不同的用法返回的错误不一样
+            _ = fn()
+        })
+    }
+}
@@ -0,0 +1,89 @@
+package mr
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// Limiter 令牌桶限流器, 用来控制 mapper 每秒开始执行的次数
+// 同一个 Limiter 可以通过 WithLimiter 传给多个同时运行的任务, 让它们共用一个配额
+type Limiter struct {
+    lock   sync.Mutex
+    // 每秒放入的令牌数
+    rate   float64
+    // 桶的容量, 也就是最多可以一下子放行多少个
+    burst  float64
+    // 当前的令牌数, 小于 0 表示已经预支了, 要等待补上
+    tokens float64
+    // 上一次计算令牌的时间
+    last   time.Time
+}
+
+// NewLimiter 创建每秒放行 rps 个, 最多一下子放行 burst 个的限流器, burst 小于 1 时按 1 处理
+// rps 小于等于 0 时不限流, burst 也不起作用
+func NewLimiter(rps float64, burst int) *Limiter {
+    if burst < 1 {
+        burst = 1
+    }
+    return &Limiter{
+        rate:   rps,
+        burst:  float64(burst),
+        tokens: float64(burst),
+        last:   time.Now(),
+    }
+}
+
+// Wait 拿到一个令牌或者 ctx 被取消才返回, ctx 被取消时返回 ctx.Err()
+func (l *Limiter) Wait(ctx context.Context) error {
+    wait := l.reserve()
+    if wait <= 0 {
+        return nil
+    }
+
+    timer := time.NewTimer(wait)
+    defer timer.Stop()
+    select {
+    case <-ctx.Done():
+        // 没用上的令牌还回去
+        l.lock.Lock()
+        l.tokens++
+        l.lock.Unlock()
+        return ctx.Err()
+    case <-timer.C:
+        return nil
+    }
+}
+
+// reserve 预支一个令牌, 返回要等待多久这个令牌才有效
+func (l *Limiter) reserve() time.Duration {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+
+    if l.rate <= 0 {
+        // 不限流, 见 NewLimiter
+        return 0
+    }
+    now := time.Now()
+    l.tokens += now.Sub(l.last).Seconds() * l.rate
+    if l.tokens > l.burst {
+        l.tokens = l.burst
+    }
+    l.last = now
+    l.tokens--
+    if l.tokens >= 0 {
+        return 0
+    }
+    return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// rateLimitMapper 每次执行 mapper 前先拿令牌, 开启重试时每次重试也要拿
+func rateLimitMapper[T, U any](mapper MapperFuncOf[T, U], limiter *Limiter) MapperFuncOf[T, U] {
+    return func(ctx context.Context, item T, writer WriterOf[U], cancel func(err error)) {
+        if err := limiter.Wait(ctx); err != nil {
+            // 任务已经结束了
+            return
+        }
+        mapper(ctx, item, writer, cancel)
+    }
+}
@@ -0,0 +1,62 @@
+package mr
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestMapReduceWithRateLimit(t *testing.T) {
+    start := time.Now()
+    _, err := MapReduceOf(context.Background(), generateInts(11), hangOn(-1), countInts, WithRateLimit(100, 1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+        t.Fatalf("want at least 100ms for 11 items at 100 rps, got %v", elapsed)
+    }
+}
+
+func TestLimiterShared(t *testing.T) {
+    limiter := NewLimiter(100, 1)
+    start := time.Now()
+    var wg sync.WaitGroup
+    for i := 0; i < 2; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if _, err := MapReduceOf(context.Background(), generateInts(6), hangOn(-1), countInts,
+                WithLimiter(limiter)); err != nil {
+                t.Error(err)
+            }
+        }()
+    }
+    wg.Wait()
+    if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+        t.Fatalf("want at least 100ms for 12 items at 100 rps, got %v", elapsed)
+    }
+}
+
+func TestLimiterWaitCanceled(t *testing.T) {
+    limiter := NewLimiter(1, 1)
+    if err := limiter.Wait(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    if err := limiter.Wait(ctx); err != context.DeadlineExceeded {
+        t.Fatalf("want %v, got %v", context.DeadlineExceeded, err)
+    }
+}
+
+func TestLimiterNonPositiveRate(t *testing.T) {
+    for _, rps := range []float64{0, -1} {
+        limiter := NewLimiter(rps, 1)
+        for i := 0; i < 10; i++ {
+            if wait := limiter.reserve(); wait != 0 {
+                t.Fatalf("rps %v: want no wait, got %v", rps, wait)
+            }
+        }
+    }
+}
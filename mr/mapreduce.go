@@ -1,11 +1,10 @@
 package mr
 
 import (
+    "context"
     "errors"
-    "fmt"
     "github.com/wanmei002/goutil/threading"
     "sync"
-    "sync/atomic"
 )
 
 // 此包主要用来并发执行方法
@@ -28,76 +27,128 @@ type (
     MapFunc func(item interface{}, writer Writer)
     // pipe 是用来保存处理传入数据的结果的, writer 用于把合并的结果集合并, cancel 如果出现错误了请调用它
     ReducerFunc  func(pipe <-chan interface{}, writer Writer, cancel func(err error))
-    
+
+    // 下面三个是带 context 的版本, ctx 在任务结束(出错、取消或者外部 ctx 被取消)时会被取消
+    GenerateFuncWithContext func(ctx context.Context, source chan<- interface{})
+    MapperFuncWithContext   func(ctx context.Context, item interface{}, writer Writer, cancel func(err error))
+    ReducerFuncWithContext  func(ctx context.Context, pipe <-chan interface{}, writer Writer, cancel func(err error))
+
     Writer interface {
         Writer(val interface{})
     }
+
+    // 下面是泛型版本, 和上面的一一对应, 不用再做类型断言了
+    // T 是要处理的数据类型, U 是 mapper 产出的结果类型, V 是 reducer 归并后的结果类型
+    // generate 也可以调用 cancel 停止任务, 比如读取数据源出错了
+    GenerateFuncOf[T any]   func(ctx context.Context, source chan<- T, cancel func(err error))
+    MapperFuncOf[T, U any]  func(ctx context.Context, item T, writer WriterOf[U], cancel func(err error))
+    ReducerFuncOf[U, V any] func(ctx context.Context, pipe <-chan U, writer WriterOf[V], cancel func(err error))
+
+    WriterOf[T any] interface {
+        Writer(val T)
+    }
 )
 
-func newWriteChan(write chan interface{}, done chan struct{}) writeChan {
-    return writeChan{
+func newWriteChan[T any](write chan T, done <-chan struct{}) writeChan[T] {
+    return writeChan[T]{
         write: write,
         done:  done,
     }
 }
 
-type writeChan struct {
-    write chan interface{}
-    done chan struct{}
+type writeChan[T any] struct {
+    write chan T
+    done <-chan struct{}
 }
 
-func (w writeChan) Writer(val interface{}) {
+func (w writeChan[T]) Writer(val T) {
+    // 任务结束后不再写入, 避免一直阻塞在这里
     select {
     case <-w.done:
         return
-    default:
-        w.write <- val
+    case w.write <- val:
     }
-    
 }
 
-func (w writeChan) Load() interface{} {
+func (w writeChan[T]) Load() T {
     return <-w.write
 }
 
 
 // 用于把要处理的数据传进chan里
-func buildSource(generate GenerateFunc) chan interface{} {
-    source := make(chan interface{})
+func buildSource[T any](ctx context.Context, generate GenerateFuncOf[T], source chan T, cancel func(err error)) {
     go func(){
         // 在这里关闭管道
         defer func(){
             close(source)
         }()
-        generate(source)
+        defer recoverToCancel(cancel)
+        generate(ctx, source, cancel)
     }()
-    
-    return source
 }
 
-func drain(channel <-chan interface{}) {
+func drain[T any](channel <-chan T) {
     for  range channel {
-    
+
+    }
+}
+
+// innerSource 在新的协程里运行 generate, 返回它写入数据的管道, 给在 generate 和 mapper 之间再加一层处理的方法用
+// 返回的方法要 defer 调用: 任务结束后 generate 可能还阻塞在写入上, 把数据读完让它退出
+func innerSource[T any](ctx context.Context, generate GenerateFuncOf[T], cancel func(err error)) (<-chan T, func()) {
+    in := make(chan T)
+    buildSource(ctx, generate, in, cancel)
+    return in, func() {
+        drain(in)
     }
 }
 
 
 func Finish(fns  ...func()error) error {
-    
-    _, err := MapReduce(func(source chan<- interface{}){
-        for _, fn := range fns {
-            source <- fn
+    return FinishWithOptions(context.Background(), wrapFinishFuncs(fns))
+}
+
+// FinishAll 和 Finish 不一样, 有方法出错也会把所有方法执行完, 返回的 *MultiError 包含每个出错方法的下标和错误
+func FinishAll(fns ...func() error) error {
+    return FinishWithOptions(context.Background(), wrapFinishFuncs(fns), WithContinueOnError())
+}
+
+func wrapFinishFuncs(fns []func() error) []func(ctx context.Context) error {
+    ctxFns := make([]func(ctx context.Context) error, 0, len(fns))
+    for _, fn := range fns {
+        fn := fn
+        ctxFns = append(ctxFns, func(context.Context) error {
+            return fn()
+        })
+    }
+
+    return ctxFns
+}
+
+// FinishWithContext 并发执行 fns, 有一个出错或者 ctx 被取消就停止, 传给 fns 的 ctx 会在这时被取消
+func FinishWithContext(ctx context.Context, fns ...func(ctx context.Context) error) error {
+    return FinishWithOptions(ctx, fns)
+}
+
+// FinishWithOptions 和 FinishWithContext 一样, fns 是可变参数, 所以 opts 只能通过这个方法传入
+func FinishWithOptions(ctx context.Context, fns []func(ctx context.Context) error, opts ...Option) error {
+    // 传下标而不是方法本身, 出错时 ItemError 里能看出来是第几个方法
+    _, err := MapReduceOf(ctx, func(ctx context.Context, source chan<- int, cancel func(err error)){
+        for i := range fns {
+            select {
+            case <-ctx.Done():
+                return
+            case source <- i:
+            }
         }
-    }, func(item interface{}, writer Writer, cancel func(err error)){
-        fmt.Println(item)
-        fn := item.(func()error)
-        if err := fn(); err != nil {
+    }, func(ctx context.Context, i int, writer WriterOf[struct{}], cancel func(err error)){
+        if err := fns[i](ctx); err != nil {
             cancel(err)
         }
-    }, func(pipe <-chan interface{}, write Writer, cancel func(err error)){
+    }, func(ctx context.Context, pipe <-chan struct{}, write WriterOf[struct{}], cancel func(err error)){
         drain(pipe)
-    })
-    
+    }, opts...)
+
     return err
 }
 
@@ -107,83 +158,72 @@ func Finish(fns  ...func()error) error {
 //2. 创建一个无缓冲的管道, 用来保存执行的结果, 让合并结果的协程从这个管道里读取数据, 然后合并数据, 写入合并数据的管道里
 //3. 创建一个用来停止其它协程的管道, 如果执行中有什么错误就关闭这个管道里，同时停止执行其它协程，返回失败
 //4. 最后要把没有关闭的管道关闭了
-func MapReduce(generate GenerateFunc, mapper MapperFunc, reducer ReducerFunc) (interface{}, error) {
-    source := buildSource(generate)
-    // 启动一个协程用于保存错误
-    // 在启动一个协程用于保存执行结果
-    // 在启动一个协程用于保存处理结果集
-    // 原子存储错误, 避免资源竞争
-    var errVal atomic.Value
-    // 执行一次关闭
-    // 如果有任何异常都把这个关闭了，让其他的接收到通知，停止运行
-    done := make(chan struct{})
-    // 创建写入实例 reducer 这个变量把结果汇总给这个chan
-    reduceChan := make(chan interface{})
-    var (
-    	cancelOnce sync.Once
-    	reduceClose sync.Once
-    )
-    finish := func(){
-        cancelOnce.Do(func(){
-            close(done)
-        })
-        reduceClose.Do(func(){
-            close(reduceChan)
-        })
+//5. 返回时启动的协程都已经退出或者很快会退出: generate 还没读的数据会被读完丢弃, mapper、reducer 的写入不再阻塞,
+//   前提是用户方法自己不会一直阻塞, 带 ctx 的版本要监听 ctx.Done(); 可以用 mrtest.VerifyNoLeaks 检查
+func MapReduce(generate GenerateFunc, mapper MapperFunc, reducer ReducerFunc, opts ...Option) (interface{}, error) {
+    return MapReduceWithContext(context.Background(), func(ctx context.Context, source chan<- interface{}) {
+        generate(source)
+    }, func(ctx context.Context, item interface{}, writer Writer, cancel func(err error)) {
+        mapper(item, writer, cancel)
+    }, func(ctx context.Context, pipe <-chan interface{}, writer Writer, cancel func(err error)) {
+        reducer(pipe, writer, cancel)
+    }, opts...)
+}
+
+// MapReduceWithContext 和 MapReduce 一样, 只是 ctx 被取消时会停止所有协程并返回 ctx.Err()
+// 传给 generate、mapper、reducer 的 ctx 在任务结束时都会被取消, 长时间运行的方法应该监听 ctx.Done()
+func MapReduceWithContext(ctx context.Context, generate GenerateFuncWithContext, mapper MapperFuncWithContext,
+    reducer ReducerFuncWithContext, opts ...Option) (interface{}, error) {
+    return MapReduceOf(ctx, func(ctx context.Context, source chan<- interface{}, cancel func(err error)) {
+        generate(ctx, source)
+    }, func(ctx context.Context, item interface{}, writer WriterOf[interface{}], cancel func(err error)) {
+        mapper(ctx, item, writer, cancel)
+    }, func(ctx context.Context, pipe <-chan interface{}, writer WriterOf[interface{}], cancel func(err error)) {
+        reducer(ctx, pipe, writer, cancel)
+    }, opts...)
+}
+
+// MapReduceOf 是 MapReduceWithContext 的泛型版本, 其它 MapReduce 方法都是基于它实现的
+func MapReduceOf[T, U, V any](ctx context.Context, generate GenerateFuncOf[T], mapper MapperFuncOf[T, U],
+    reducer ReducerFuncOf[U, V], opts ...Option) (V, error) {
+    options := buildOptions(opts...)
+    if !options.decorated {
+        mapper = decorateMapper(mapper, options)
     }
-    
-    cancel := func(err error){
-        
-        if err != nil {
-            errVal.Store(err)
-        } else {
-            errVal.Store(cancelWithNil)
-        }
-        defer func(){
-            // 把资源管道里的数据清空
-            drain(source)
-        }()
-    
-        finish()
+
+    return runJob(ctx, options, func(j *job) <-chan U {
+        source := make(chan T, options.sourceBuffer)
+        buildSource(j.ctx, generate, source, j.cancel)
+        // 现在开始从执行管道里读取数据处理
+        return mapStage(j, source, mapper, options)
+    }, reducer)
+}
+
+// decorateMapper 按 options 给 mapper 加上超时、限流、重试等功能
+func decorateMapper[T, U any](mapper MapperFuncOf[T, U], options *mapReduceOptions) MapperFuncOf[T, U] {
+    // 先加超时再加重试, 每次重试都有自己的超时时间, 等待令牌的时间不算在超时里
+    if options.itemTimeout > 0 {
+        mapper = timeoutMapper(mapper, options.itemTimeout, options.timeoutPolicy)
     }
-    write := newWriteChan(reduceChan, done)
-    // 存放处理结果, mapper 往这个里面写入， reduce 从这个里面读出
-    resChan := make(chan interface{})
-    // 启动协程 执行结果集归并方法
-    go func(){
-        
-        defer func(){
-            finish()
-            drain(resChan)
-        }()
-        // 在这里可能遇到错误就结束运行了, reschan 可能还有数据, 所以要在 defer 中把数据都给读取完
-        reducer(resChan, write, cancel)
-    }()
-    
-    
-   // 现在开始从执行管道里读取数据处理
-   go executeMappers(func(item interface{}, writer Writer) {
-       mapper(item, writer, cancel)
-   }, resChan, done, source)
-   
-   
-   // 此时我们应该取出错误 和 结果
-   res, ok := <-reduceChan
-   errIntF := errVal.Load()
-   var err error
-   if errIntF != nil {
-       err = errIntF.(error)
-       return nil, err
-   }
-   if !ok {
-       return nil, err
-   } else {
-       return res, nil
-   }
+    if options.limiter != nil {
+        mapper = rateLimitMapper(mapper, options.limiter)
+    }
+    // 备份执行也要拿令牌, 重试时重新计时
+    if options.speculation != nil {
+        mapper = speculativeMapper(mapper, *options.speculation)
+    }
+    if options.retry != nil {
+        mapper = retryMapper(mapper, options.retry)
+    }
+    if options.checkpoint != nil {
+        mapper = checkpointMapper(mapper, options.checkpoint)
+    }
+
+    return mapper
 }
 
 // executeMappers
-func executeMappers(mapper MapFunc,resChan chan interface{}, done chan struct{},source <-chan interface{}){
+func executeMappers[T, U any](mapper func(item T, writer WriterOf[U]),resChan chan U, done <-chan struct{},source <-chan T, pool *workerPool){
     wg := sync.WaitGroup{}
     defer func(){
         wg.Wait()
@@ -191,31 +231,41 @@ func executeMappers(mapper MapFunc,resChan chan interface{}, done chan struct{},
         close(resChan)
     }()
     writer := newWriteChan(resChan, done)
-    // 在这里建一个管道 控制开启的协程数量
-    pool := make(chan struct{}, 16)
+    // pool 控制开启的协程数量
     for {
+        if !pool.acquire(done) {
+            // 把资源管道里的数据清空, 让阻塞在写入上的 generate 或者上一个阶段退出
+            // 这里不等它清空, 上游可能要等 mapper 都退出后才能结束
+            go drain(source)
+            return
+        }
+        // 读取要处理的数据, 上游可能很久都没有数据, 任务结束时不再等它
+        var (
+            item T
+            ok   bool
+        )
         select {
         case <-done:
+            pool.release()
+            go drain(source)
+            return
+        case item, ok = <-source:
+        }
+        // 在这里判断管道是否关闭了
+        if !ok {// 说明管道已经关闭了
+            pool.release()
             return
-        // 读取要处理的数据
-        case pool <- struct{}{}:
-            // 在这里判断管道是否关闭了
-            item, ok := <-source
-            if !ok {// 说明管道已经关闭了
-                <-pool
-                return
-            }
-            wg.Add(1)
-            threading.SafeGoroutine(func(){
-                defer func(){
-                    wg.Done()
-                    // 在这里关闭, 以保证最多有 16 个在进行
-                    <-pool
-                }()
-    
-                // 运行自定义的处理函数
-                mapper(item, writer)
-            })
         }
+        wg.Add(1)
+        threading.SafeGoroutine(func(){
+            defer func(){
+                wg.Done()
+                // 在这里释放, 以保证最多有 limit 个在进行
+                pool.release()
+            }()
+
+            // 运行自定义的处理函数
+            mapper(item, writer)
+        })
     }
 }
@@ -0,0 +1,176 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestMapReduce(t *testing.T) {
+    res, err := MapReduce(func(source chan<- interface{}) {
+        for i := 1; i <= 10; i++ {
+            source <- i
+        }
+    }, func(item interface{}, writer Writer, cancel func(err error)) {
+        writer.Writer(item.(int) * item.(int))
+    }, func(pipe <-chan interface{}, writer Writer, cancel func(err error)) {
+        var sum int
+        for v := range pipe {
+            sum += v.(int)
+        }
+        writer.Writer(sum)
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res.(int) != 385 {
+        t.Fatalf("want 385, got %v", res)
+    }
+}
+
+func TestFinish(t *testing.T) {
+    errDummy := errors.New("dummy")
+    if err := Finish(func() error { return nil }, func() error { return nil }); err != nil {
+        t.Fatal(err)
+    }
+    if err := Finish(func() error { return nil }, func() error { return errDummy }); err != errDummy {
+        t.Fatalf("want %v, got %v", errDummy, err)
+    }
+}
+
+func TestMapReduceWithContextCancel(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+
+    _, err := MapReduceWithContext(ctx, func(ctx context.Context, source chan<- interface{}) {
+        for i := 0; ; i++ {
+            select {
+            case <-ctx.Done():
+                return
+            case source <- i:
+            }
+        }
+    }, func(ctx context.Context, item interface{}, writer Writer, cancel func(err error)) {
+        <-ctx.Done()
+    }, func(ctx context.Context, pipe <-chan interface{}, writer Writer, cancel func(err error)) {
+        drain(pipe)
+    })
+    if err != context.DeadlineExceeded {
+        t.Fatalf("want %v, got %v", context.DeadlineExceeded, err)
+    }
+}
+
+func TestFinishWithContextCancel(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    go func() {
+        time.Sleep(20 * time.Millisecond)
+        cancel()
+    }()
+
+    err := FinishWithContext(ctx, func(ctx context.Context) error {
+        <-ctx.Done()
+        return nil
+    })
+    if err != context.Canceled {
+        t.Fatalf("want %v, got %v", context.Canceled, err)
+    }
+}
+
+func TestMapReduceWithWorkers(t *testing.T) {
+    var running, maxRunning int32
+    _, err := MapReduce(func(source chan<- interface{}) {
+        for i := 0; i < 20; i++ {
+            source <- i
+        }
+    }, func(item interface{}, writer Writer, cancel func(err error)) {
+        n := atomic.AddInt32(&running, 1)
+        for {
+            m := atomic.LoadInt32(&maxRunning)
+            if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+                break
+            }
+        }
+        time.Sleep(5 * time.Millisecond)
+        atomic.AddInt32(&running, -1)
+        writer.Writer(item)
+    }, func(pipe <-chan interface{}, writer Writer, cancel func(err error)) {
+        drain(pipe)
+    }, WithWorkers(3), WithSourceBuffer(5), WithResultBuffer(5))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if maxRunning > 3 {
+        t.Fatalf("want at most 3 mappers running, got %d", maxRunning)
+    }
+}
+
+func TestMapReducePanic(t *testing.T) {
+    generate := func(source chan<- interface{}) {
+        for i := 0; i < 10; i++ {
+            source <- i
+        }
+    }
+    mapper := func(item interface{}, writer Writer, cancel func(err error)) {
+        writer.Writer(item)
+    }
+    reducer := func(pipe <-chan interface{}, writer Writer, cancel func(err error)) {
+        drain(pipe)
+    }
+
+    tests := map[string]func() (interface{}, error){
+        "generate": func() (interface{}, error) {
+            return MapReduce(func(source chan<- interface{}) {
+                panic("generate")
+            }, mapper, reducer)
+        },
+        "mapper": func() (interface{}, error) {
+            return MapReduce(generate, func(item interface{}, writer Writer, cancel func(err error)) {
+                if item.(int) == 5 {
+                    panic("mapper")
+                }
+                writer.Writer(item)
+            }, reducer)
+        },
+        "reducer": func() (interface{}, error) {
+            return MapReduce(generate, mapper, func(pipe <-chan interface{}, writer Writer, cancel func(err error)) {
+                panic("reducer")
+            })
+        },
+    }
+    for name, fn := range tests {
+        _, err := fn()
+        var pe *PanicError
+        if !errors.As(err, &pe) {
+            t.Fatalf("%s: want *PanicError, got %v", name, err)
+        }
+        if pe.Value != name || len(pe.Stack) == 0 {
+            t.Fatalf("%s: unexpected panic error: %v", name, pe)
+        }
+    }
+}
+
+func TestMapReduceOf(t *testing.T) {
+    res, err := MapReduceOf(context.Background(), func(ctx context.Context, source chan<- int, cancel func(err error)) {
+        for i := 1; i <= 10; i++ {
+            source <- i
+        }
+    }, func(ctx context.Context, item int, writer WriterOf[string], cancel func(err error)) {
+        if item%2 == 0 {
+            writer.Writer("x")
+        }
+    }, func(ctx context.Context, pipe <-chan string, writer WriterOf[int], cancel func(err error)) {
+        var n int
+        for v := range pipe {
+            n += len(v)
+        }
+        writer.Writer(n)
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res != 5 {
+        t.Fatalf("want 5, got %d", res)
+    }
+}
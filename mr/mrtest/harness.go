@@ -0,0 +1,331 @@
+package mrtest
+
+import (
+    "context"
+    "fmt"
+    "math/rand"
+    "runtime/debug"
+    "sort"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/wanmei002/goutil/mr"
+)
+
+// 确定性运行: generate 写入的数据先放进一个有上限的窗口, 窗口满了(或者 generate 结束了)后按种子从窗口里随机取一个交给 mapper,
+// 只用一个 mapper 协程逐个处理, 同样的种子每次处理数据的顺序都一样, reducer 收到结果的顺序也一样, 出错时可以用 Result.Order 重放
+// 数据是边读边处理的, 没有数据结束的 generate(比如 mr.FromFunc、mr.FromChan)也可以用, 任务结束时 generate 还在运行,
+// 可以检查它有没有跟着退出
+// 能重放的只有数据的处理顺序: 只有一个 mapper 协程, 不能控制多个 mapper 同时运行时的交错执行,
+// 依赖 mapper 之间并发的问题(比如数据竞争)要用 -race 和真实的并发测试
+// 还可以在指定的数据上注入错误或者 panic, 检查任务结束后各个阶段的方法是不是都在规定时间内退出了
+
+const (
+    // 默认的窗口大小
+    defaultWindow = 16
+)
+
+// Scenario 描述一次确定性运行的调度顺序和要注入的故障
+type Scenario struct {
+    // 打乱数据顺序用的种子
+    Seed int64
+    // 最多暂存多少个数据用来打乱顺序, 默认 16, 1 表示按 generate 写入的顺序处理
+    Window int
+    // 指定 mapper 处理数据的顺序, 每个值是数据在 generate 写入顺序里的下标, 不为空时忽略 Seed 和 Window
+    // 一般是之前某次运行的 Result.Order, 用来重放; 排完这些数据后剩下的按写入顺序处理
+    Order []int
+
+    faults map[int]fault
+}
+
+// fault 在某个数据上注入的故障, err 为 nil 时 panic
+type fault struct {
+    err   error
+    panic interface{}
+}
+
+// NewScenario 创建按 seed 打乱数据顺序的 Scenario
+func NewScenario(seed int64) *Scenario {
+    return &Scenario{Seed: seed}
+}
+
+// FailAt 第 i 个数据(按 generate 写入的顺序, 从 0 开始)不执行 mapper, 直接调用 cancel(err)
+func (s *Scenario) FailAt(i int, err error) *Scenario {
+    s.setFault(i, fault{err: err})
+    return s
+}
+
+// PanicAt 第 i 个数据不执行 mapper, 直接 panic(value)
+func (s *Scenario) PanicAt(i int, value interface{}) *Scenario {
+    s.setFault(i, fault{panic: value})
+    return s
+}
+
+func (s *Scenario) setFault(i int, f fault) {
+    if s.faults == nil {
+        s.faults = make(map[int]fault)
+    }
+    s.faults[i] = f
+}
+
+// Result 一次确定性运行的结果
+type Result[V any] struct {
+    Value V
+    Err   error
+    // mapper 实际处理数据的顺序, 任务提前结束时比数据个数少, 可以传给 Scenario.Order 重放
+    Order []int
+
+    lock     sync.Mutex
+    // 各个阶段的用户方法返回时关闭
+    generate chan struct{}
+    mappers  []chan struct{}
+    reducer  chan struct{}
+}
+
+// indexed 带有 generate 写入顺序下标的数据
+type indexed[T any] struct {
+    index int
+    item  T
+}
+
+// Run 按 s 确定性地运行任务, 参数和 mr.MapReduceOf 一样
+// opts 里的 WithWorkers 会被覆盖成 1, 不要使用 WithAdaptiveConcurrency、WithSpeculation 这些会改变并发的参数
+func Run[T, U, V any](ctx context.Context, s *Scenario, generate mr.GenerateFuncOf[T], mapper mr.MapperFuncOf[T, U],
+    reducer mr.ReducerFuncOf[U, V], opts ...mr.Option) *Result[V] {
+    res := new(Result[V])
+    opts = append(opts[:len(opts):len(opts)], mr.WithWorkers(1), mr.WithSourceBuffer(0))
+    value, err := mr.MapReduceOf(ctx, func(ctx context.Context, source chan<- indexed[T], cancel func(err error)) {
+        exited := make(chan struct{})
+        res.lock.Lock()
+        res.generate = exited
+        res.lock.Unlock()
+
+        in := startGenerate(ctx, generate, cancel, exited)
+        // 和 mr 一样, 任务结束后把剩下的数据读完, 让阻塞在写入上的 generate 退出
+        defer func() {
+            go drain(in)
+        }()
+        if err := feed(ctx, s, in, source); err != nil {
+            cancel(err)
+        }
+    }, func(ctx context.Context, item indexed[T], writer mr.WriterOf[U], cancel func(err error)) {
+        // 任务结束时可能还有数据被交给 mapper, 不处理, 不然每次运行处理的数据个数不一样
+        if ctx.Err() != nil {
+            return
+        }
+        exited := make(chan struct{})
+        defer close(exited)
+        res.lock.Lock()
+        res.mappers = append(res.mappers, exited)
+        res.Order = append(res.Order, item.index)
+        res.lock.Unlock()
+
+        if f, ok := s.faults[item.index]; ok {
+            if f.err != nil {
+                cancel(f.err)
+                return
+            }
+            panic(f.panic)
+        }
+        mapper(ctx, item.item, writer, cancel)
+    }, func(ctx context.Context, pipe <-chan U, writer mr.WriterOf[V], cancel func(err error)) {
+        exited := make(chan struct{})
+        defer close(exited)
+        res.lock.Lock()
+        res.reducer = exited
+        res.lock.Unlock()
+
+        reducer(ctx, pipe, writer, cancel)
+    }, opts...)
+
+    res.lock.Lock()
+    res.Value, res.Err = value, err
+    res.lock.Unlock()
+    return res
+}
+
+// startGenerate 在新的协程里运行 generate, 返回它写入数据的管道, generate 返回时关闭 exited
+func startGenerate[T any](ctx context.Context, generate mr.GenerateFuncOf[T], cancel func(err error),
+    exited chan struct{}) <-chan T {
+    ch := make(chan T)
+    go func() {
+        defer close(ch)
+        defer close(exited)
+        defer func() {
+            if r := recover(); r != nil {
+                cancel(&mr.PanicError{Value: r, Stack: debug.Stack()})
+            }
+        }()
+        generate(ctx, ch, cancel)
+    }()
+    return ch
+}
+
+func drain[T any](ch <-chan T) {
+    for range ch {
+    }
+}
+
+// reader 从 generate 的管道里读数据, 按读到的顺序编号
+type reader[T any] struct {
+    in <-chan T
+    // 已经读到的数据个数
+    n  int
+}
+
+// next 读一个数据, generate 结束或者任务结束时返回 false
+func (r *reader[T]) next(ctx context.Context) (indexed[T], bool) {
+    select {
+    case <-ctx.Done():
+        return indexed[T]{}, false
+    case item, ok := <-r.in:
+        if !ok {
+            return indexed[T]{}, false
+        }
+        r.n++
+        return indexed[T]{index: r.n - 1, item: item}, true
+    }
+}
+
+// feed 按 s 的顺序把 in 里的数据写入 source, 任务结束时返回 nil
+func feed[T any](ctx context.Context, s *Scenario, in <-chan T, source chan<- indexed[T]) error {
+    r := &reader[T]{in: in}
+    send := func(item indexed[T]) bool {
+        select {
+        case <-ctx.Done():
+            return false
+        case source <- item:
+            return true
+        }
+    }
+    if len(s.Order) > 0 {
+        return replay(ctx, s.Order, r, send)
+    }
+
+    window := s.Window
+    if window < 1 {
+        window = defaultWindow
+    }
+    // 窗口满了或者没有数据了才取, 取到哪个数据只和种子有关, 和 generate 写入的快慢无关
+    rng := rand.New(rand.NewSource(s.Seed))
+    var buf []indexed[T]
+    for {
+        for len(buf) < window {
+            item, ok := r.next(ctx)
+            if !ok {
+                break
+            }
+            buf = append(buf, item)
+        }
+        if ctx.Err() != nil || len(buf) == 0 {
+            return nil
+        }
+        i := rng.Intn(len(buf))
+        item := buf[i]
+        buf[i] = buf[len(buf)-1]
+        buf = buf[:len(buf)-1]
+        if !send(item) {
+            return nil
+        }
+    }
+}
+
+// replay 按 order 写入数据, 还没读到的数据先往后读, 读到的其它数据暂存起来
+func replay[T any](ctx context.Context, order []int, r *reader[T], send func(item indexed[T]) bool) error {
+    seen := make(map[int]bool, len(order))
+    for _, i := range order {
+        if i < 0 || seen[i] {
+            return fmt.Errorf("mrtest: order %v has a negative or repeated index %d", order, i)
+        }
+        seen[i] = true
+    }
+
+    pending := make(map[int]indexed[T])
+    for _, i := range order {
+        for {
+            if _, ok := pending[i]; ok {
+                break
+            }
+            item, ok := r.next(ctx)
+            if !ok {
+                if ctx.Err() != nil {
+                    return nil
+                }
+                return fmt.Errorf("mrtest: order index %d is out of range, generate wrote %d items", i, r.n)
+            }
+            pending[item.index] = item
+        }
+        item := pending[i]
+        delete(pending, i)
+        if !send(item) {
+            return nil
+        }
+    }
+
+    // 剩下的数据按写入顺序处理, 先是暂存的, 再是还没读的
+    rest := make([]int, 0, len(pending))
+    for i := range pending {
+        rest = append(rest, i)
+    }
+    sort.Ints(rest)
+    for _, i := range rest {
+        if !send(pending[i]) {
+            return nil
+        }
+    }
+    for {
+        item, ok := r.next(ctx)
+        if !ok || !send(item) {
+            return nil
+        }
+    }
+}
+
+// AssertCanceled 检查 generate、每次 mapper 调用和 reducer 是不是都在 1 秒内退出了, 要在 Run 返回后调用
+// 任务结束时它们的 ctx 都会被取消, 没有退出说明用户方法没有监听 ctx.Done()
+func (r *Result[V]) AssertCanceled(t testing.TB) {
+    t.Helper()
+    r.AssertCanceledWithin(t, defaultExitTimeout)
+}
+
+// AssertCanceledWithin 和 AssertCanceled 一样, 最多等待 timeout
+func (r *Result[V]) AssertCanceledWithin(t testing.TB, timeout time.Duration) {
+    t.Helper()
+    r.lock.Lock()
+    generate, mappers, reducer := r.generate, r.mappers, r.reducer
+    order := r.Order
+    r.lock.Unlock()
+
+    deadline := time.Now().Add(timeout)
+    exited := func(ch chan struct{}) bool {
+        select {
+        case <-ch:
+            return true
+        default:
+        }
+        wait := time.Until(deadline)
+        if wait <= 0 {
+            return false
+        }
+        timer := time.NewTimer(wait)
+        defer timer.Stop()
+        select {
+        case <-ch:
+            return true
+        case <-timer.C:
+            return false
+        }
+    }
+    if generate != nil && !exited(generate) {
+        t.Errorf("mrtest: generate did not exit within %v", timeout)
+    }
+    for i, ch := range mappers {
+        if !exited(ch) {
+            t.Errorf("mrtest: mapper for item %d did not exit within %v", order[i], timeout)
+        }
+    }
+    if reducer != nil && !exited(reducer) {
+        t.Errorf("mrtest: reducer did not exit within %v", timeout)
+    }
+}
@@ -0,0 +1,161 @@
+package mrtest
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/wanmei002/goutil/mr"
+)
+
+func collectInts(ctx context.Context, pipe <-chan int, writer mr.WriterOf[[]int], cancel func(err error)) {
+    var res []int
+    for v := range pipe {
+        res = append(res, v)
+    }
+    writer.Writer(res)
+}
+
+func double(ctx context.Context, item int, writer mr.WriterOf[int], cancel func(err error)) {
+    writer.Writer(item * 2)
+}
+
+func TestRunDeterministic(t *testing.T) {
+    generate := mr.FromSlice([]int{1, 2, 3, 4, 5, 6, 7, 8})
+    first := Run(context.Background(), NewScenario(42), generate, double, collectInts)
+    if first.Err != nil {
+        t.Fatal(first.Err)
+    }
+    second := Run(context.Background(), NewScenario(42), generate, double, collectInts)
+    replay := Run(context.Background(), &Scenario{Order: first.Order}, generate, double, collectInts)
+    for _, res := range []*Result[[]int]{second, replay} {
+        if res.Err != nil {
+            t.Fatal(res.Err)
+        }
+        if len(res.Value) != len(first.Value) {
+            t.Fatalf("want %v, got %v", first.Value, res.Value)
+        }
+        for i := range first.Value {
+            if res.Value[i] != first.Value[i] {
+                t.Fatalf("want %v, got %v", first.Value, res.Value)
+            }
+        }
+    }
+    for i, index := range first.Order {
+        if first.Value[i] != (index+1)*2 {
+            t.Fatalf("results do not follow order %v: %v", first.Order, first.Value)
+        }
+    }
+    first.AssertCanceled(t)
+}
+
+func TestRunFaults(t *testing.T) {
+    errDummy := errors.New("dummy")
+    generate := mr.FromSlice([]int{1, 2, 3, 4, 5})
+
+    res := Run(context.Background(), NewScenario(1).FailAt(2, errDummy), generate, double, collectInts)
+    if res.Err != errDummy {
+        t.Fatalf("want %v, got %v", errDummy, res.Err)
+    }
+    if last := res.Order[len(res.Order)-1]; last != 2 {
+        t.Fatalf("want no item processed after the failed one, got order %v", res.Order)
+    }
+    res.AssertCanceled(t)
+
+    res = Run(context.Background(), NewScenario(1).PanicAt(3, "boom"), generate, double, collectInts)
+    var pe *mr.PanicError
+    if !errors.As(res.Err, &pe) || pe.Value != "boom" {
+        t.Fatalf("want *mr.PanicError, got %v", res.Err)
+    }
+    res.AssertCanceled(t)
+
+    for _, order := range [][]int{{0, 5}, {1, 1}, {-1}} {
+        res = Run(context.Background(), &Scenario{Order: order}, generate, double, collectInts)
+        if res.Err == nil {
+            t.Fatalf("want error for bad order %v", order)
+        }
+        res.AssertCanceled(t)
+    }
+
+    // 排完 Order 后剩下的数据按写入顺序处理
+    res = Run(context.Background(), &Scenario{Order: []int{3, 1}}, generate, double, collectInts)
+    if res.Err != nil {
+        t.Fatal(res.Err)
+    }
+    if want := []int{3, 1, 0, 2, 4}; fmt.Sprint(res.Order) != fmt.Sprint(want) {
+        t.Fatalf("want order %v, got %v", want, res.Order)
+    }
+}
+
+func TestRunEndlessGenerate(t *testing.T) {
+    errDummy := errors.New("dummy")
+    var n int
+    generate := mr.FromFunc(func() (int, bool, error) {
+        n++
+        return n, true, nil
+    })
+    done := make(chan *Result[[]int], 1)
+    go func() {
+        done <- Run(context.Background(), NewScenario(1).FailAt(20, errDummy), generate, double, collectInts)
+    }()
+    select {
+    case res := <-done:
+        if res.Err != errDummy {
+            t.Fatalf("want %v, got %v", errDummy, res.Err)
+        }
+        res.AssertCanceled(t)
+    case <-time.After(time.Second):
+        t.Fatal("Run did not return for an endless generate")
+    }
+}
+
+func TestAssertCanceledBlockedGenerate(t *testing.T) {
+    release := make(chan struct{})
+    defer close(release)
+
+    // generate 不监听 ctx.Done(), 任务结束后还在一直写入
+    res := Run(context.Background(), NewScenario(1).FailAt(3, errors.New("dummy")), func(ctx context.Context,
+        source chan<- int, cancel func(err error)) {
+        for i := 0; ; i++ {
+            select {
+            case source <- i:
+            case <-release:
+                return
+            }
+        }
+    }, double, collectInts)
+    if res.Err == nil {
+        t.Fatal("want error")
+    }
+
+    r := &recorder{TB: t}
+    res.AssertCanceledWithin(r, 20*time.Millisecond)
+    if len(r.errs) != 1 || !strings.Contains(r.errs[0], "generate") {
+        t.Fatalf("want the blocked generate reported, got %v", r.errs)
+    }
+}
+
+func TestAssertCanceledBlockedMapper(t *testing.T) {
+    release := make(chan struct{})
+    defer close(release)
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+
+    // mapper 不监听 ctx.Done(), 任务结束后也不会退出
+    res := Run(ctx, NewScenario(1), mr.FromSlice([]int{1, 2, 3}), func(ctx context.Context, item int,
+        writer mr.WriterOf[int], cancel func(err error)) {
+        <-release
+    }, collectInts)
+    if res.Err != context.DeadlineExceeded {
+        t.Fatalf("want %v, got %v", context.DeadlineExceeded, res.Err)
+    }
+
+    r := &recorder{TB: t}
+    res.AssertCanceledWithin(r, 20*time.Millisecond)
+    if len(r.errs) != 2 || !strings.Contains(r.errs[0], "mapper") || !strings.Contains(r.errs[1], "reducer") {
+        t.Fatalf("want the blocked mapper and reducer reported, got %v", r.errs)
+    }
+}
@@ -0,0 +1,112 @@
+// Package mrtest 提供测试 mr 包任务时用的工具: 检查协程泄漏, 以及确定性地运行任务
+// 确定性运行只用一个 mapper 协程, 按种子打乱和重放的是数据的处理顺序; 它不是调度器,
+// 不能控制或者重放多个 mapper 之间的交错执行
+package mrtest
+
+import (
+    "bytes"
+    "runtime"
+    "strings"
+    "testing"
+    "time"
+)
+
+const (
+    // 默认等待协程退出的时间
+    defaultExitTimeout = time.Second
+)
+
+// VerifyNoLeaks 记录当前所有的协程, 返回的方法检查之后启动的协程是不是都退出了, 一般这样用:
+//     defer mrtest.VerifyNoLeaks(t)()
+// 协程可能正在退出, 检查时最多等待 1 秒; 其它测试并行运行时启动的协程也会被当成泄漏, 不要和 t.Parallel 一起用
+func VerifyNoLeaks(t testing.TB) func() {
+    return VerifyNoLeaksTimeout(t, defaultExitTimeout)
+}
+
+// VerifyNoLeaksTimeout 和 VerifyNoLeaks 一样, 最多等待 timeout
+func VerifyNoLeaksTimeout(t testing.TB, timeout time.Duration) func() {
+    t.Helper()
+    before := make(map[string]struct{})
+    for _, g := range goroutines() {
+        before[g.id] = struct{}{}
+    }
+
+    return func() {
+        t.Helper()
+        deadline := time.Now().Add(timeout)
+        wait := time.Millisecond
+        for {
+            var leaked []string
+            for _, g := range goroutines() {
+                if _, ok := before[g.id]; !ok && !g.ignored() {
+                    leaked = append(leaked, g.stack)
+                }
+            }
+            if len(leaked) == 0 {
+                return
+            }
+            if time.Now().After(deadline) {
+                t.Errorf("found %d leaked goroutines:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+                return
+            }
+            time.Sleep(wait)
+            if wait < 100*time.Millisecond {
+                wait *= 2
+            }
+        }
+    }
+}
+
+// ignoredFuncs 堆栈里包含这些方法的协程不算泄漏
+var ignoredFuncs = []string{
+    "runtime.gcBgMarkWorker",
+    "runtime.bgsweep",
+    "runtime.bgscavenge",
+    "runtime.forcegchelper",
+    "runtime.runfinq",
+    "runtime.ensureSigM",
+    "os/signal.signal_recv",
+}
+
+type goroutine struct {
+    id    string
+    stack string
+}
+
+// ignored 不是被测代码启动的协程, 比如 runtime 在需要时才启动的后台协程
+func (g goroutine) ignored() bool {
+    for _, fn := range ignoredFuncs {
+        if strings.Contains(g.stack, fn) {
+            return true
+        }
+    }
+    return false
+}
+
+// goroutines 返回所有协程的 id 和堆栈
+func goroutines() []goroutine {
+    buf := make([]byte, 64<<10)
+    for {
+        n := runtime.Stack(buf, true)
+        if n < len(buf) {
+            buf = buf[:n]
+            break
+        }
+        buf = make([]byte, len(buf)*2)
+    }
+
+    var res []goroutine
+    for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+        // 第一行是 goroutine 123 [running]:
+        header := string(stack)
+        if i := strings.IndexByte(header, '\n'); i >= 0 {
+            header = header[:i]
+        }
+        fields := strings.Fields(header)
+        if len(fields) < 2 || fields[0] != "goroutine" {
+            continue
+        }
+        res = append(res, goroutine{id: fields[1], stack: string(stack)})
+    }
+    return res
+}
@@ -0,0 +1,47 @@
+package mrtest
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+)
+
+// recorder 记录 Errorf, 不让测试失败
+type recorder struct {
+    testing.TB
+    errs []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+    r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func TestVerifyNoLeaks(t *testing.T) {
+    r := &recorder{TB: t}
+    verify := VerifyNoLeaksTimeout(r, 50*time.Millisecond)
+    stop := make(chan struct{})
+    go func() {
+        <-stop
+    }()
+    verify()
+    close(stop)
+    if len(r.errs) != 1 || !strings.Contains(r.errs[0], "leaked goroutines") {
+        t.Fatalf("want a leak reported, got %v", r.errs)
+    }
+
+    r = &recorder{TB: t}
+    verify = VerifyNoLeaksTimeout(r, time.Second)
+    done := make(chan struct{})
+    go func() {
+        time.Sleep(10 * time.Millisecond)
+        close(done)
+    }()
+    verify()
+    <-done
+    if len(r.errs) != 0 {
+        t.Fatalf("want no leak, got %v", r.errs)
+    }
+}
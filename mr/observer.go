@@ -0,0 +1,28 @@
+package mr
+
+import "time"
+
+// Observer 用来观察任务的运行情况, 比如接入监控
+// 方法会在 mapper 等协程里同步调用, 要并发安全并且尽快返回
+type Observer interface {
+    // mapper 开始处理 item
+    OnItemStart(item interface{})
+    // mapper 处理完 item, attempts 是一共执行了几次, 开启 WithRetry 时包含重试的次数,
+    // err 是 mapper 调用 cancel 传入的错误或者 panic, 任务已经结束时是 ctx 的错误
+    OnItemFinish(item interface{}, latency time.Duration, attempts int, err error)
+    // 任务因为出错、超时或者 ctx 被取消停止了, 只会调用一次
+    OnJobCancel(err error)
+    // 任务结束, 在 MapReduce 等方法返回前调用
+    OnJobEnd(stats Stats)
+}
+
+// NopObserver 什么都不做, 嵌入到自己的类型里就只用实现关心的方法
+type NopObserver struct{}
+
+func (NopObserver) OnItemStart(item interface{}) {}
+
+func (NopObserver) OnItemFinish(item interface{}, latency time.Duration, attempts int, err error) {}
+
+func (NopObserver) OnJobCancel(err error) {}
+
+func (NopObserver) OnJobEnd(stats Stats) {}
@@ -0,0 +1,138 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+type countingObserver struct {
+    NopObserver
+    started  int64
+    finished int64
+    canceled int64
+    // 所有数据的执行次数加起来
+    attempts int64
+    ended    chan Stats
+}
+
+func (o *countingObserver) OnItemStart(item interface{}) {
+    atomic.AddInt64(&o.started, 1)
+}
+
+func (o *countingObserver) OnItemFinish(item interface{}, latency time.Duration, attempts int, err error) {
+    atomic.AddInt64(&o.finished, 1)
+    atomic.AddInt64(&o.attempts, int64(attempts))
+}
+
+func (o *countingObserver) OnJobCancel(err error) {
+    atomic.AddInt64(&o.canceled, 1)
+}
+
+func (o *countingObserver) OnJobEnd(stats Stats) {
+    o.ended <- stats
+}
+
+func TestMapReduceWithObserver(t *testing.T) {
+    observer := &countingObserver{ended: make(chan Stats, 1)}
+    var stats Stats
+    _, err := MapReduceOf(context.Background(), generateInts(100), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        time.Sleep(time.Duration(item%5) * time.Millisecond)
+        if item%10 == 0 {
+            cancel(errors.New("dummy"))
+            return
+        }
+        writer.Writer(item)
+    }, countInts, WithContinueOnError(), WithObserver(observer), WithStats(&stats))
+    if err == nil {
+        t.Fatal("want errors")
+    }
+
+    ended := <-observer.ended
+    if ended != stats {
+        t.Fatalf("want the same stats from observer and WithStats, got %+v and %+v", ended, stats)
+    }
+    if observer.started != 100 || observer.finished != 100 || observer.canceled != 0 {
+        t.Fatalf("unexpected observer calls: %+v", observer)
+    }
+    if stats.Generated != 100 || stats.Mapped != 90 || stats.Failed != 10 || stats.Canceled != 0 {
+        t.Fatalf("unexpected stats: %+v", stats)
+    }
+    if stats.Latency.P50 <= 0 || stats.Latency.P99 < stats.Latency.P50 || stats.Latency.Max < stats.Latency.P99 {
+        t.Fatalf("unexpected latency: %+v", stats.Latency)
+    }
+    if stats.ReduceWait <= 0 || stats.Duration < stats.ReduceWait {
+        t.Fatalf("unexpected durations: %+v", stats)
+    }
+}
+
+func TestMapReduceObserverParentCancel(t *testing.T) {
+    for i := 0; i < 20; i++ {
+        observer := &countingObserver{ended: make(chan Stats, 1)}
+        ctx, cancel := context.WithCancel(context.Background())
+        go func() {
+            time.Sleep(time.Millisecond)
+            cancel()
+        }()
+        // reducer 监听 ctx.Done(), 会先于外部 ctx 的监听协程结束任务
+        _, err := MapReduceOf(ctx, generateInts(1000000), func(ctx context.Context, item int,
+            writer WriterOf[int], cancel func(err error)) {
+            writer.Writer(item)
+        }, func(ctx context.Context, pipe <-chan int, writer WriterOf[int], cancel func(err error)) {
+            for {
+                select {
+                case <-ctx.Done():
+                    return
+                case <-pipe:
+                }
+            }
+        }, WithObserver(observer))
+        if err != context.Canceled {
+            t.Fatalf("want %v, got %v", context.Canceled, err)
+        }
+        <-observer.ended
+        if n := atomic.LoadInt64(&observer.canceled); n != 1 {
+            t.Fatalf("want OnJobCancel called once, got %d", n)
+        }
+    }
+}
+
+func TestMapReduceObserverJobCancel(t *testing.T) {
+    observer := &countingObserver{ended: make(chan Stats, 1)}
+    errDummy := errors.New("dummy")
+    _, err := MapReduceOf(context.Background(), generateInts(100), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        cancel(errDummy)
+    }, countInts, WithObserver(observer))
+    if err != errDummy {
+        t.Fatalf("want %v, got %v", errDummy, err)
+    }
+    <-observer.ended
+    if atomic.LoadInt64(&observer.canceled) != 1 {
+        t.Fatalf("want OnJobCancel called once, got %d", observer.canceled)
+    }
+}
+
+func TestMapReduceObserverAttempts(t *testing.T) {
+    observer := &countingObserver{ended: make(chan Stats, 1)}
+    var stats Stats
+    _, err := MapReduceOf(context.Background(), generateInts(10), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        // 偶数第一次执行失败, 重试一次后成功
+        if item%2 == 0 && Attempt(ctx) == 1 {
+            cancel(errors.New("dummy"))
+            return
+        }
+        writer.Writer(item)
+    }, countInts, WithRetry(RetryPolicy{MaxAttempts: 3}), WithObserver(observer), WithStats(&stats))
+    if err != nil {
+        t.Fatal(err)
+    }
+    <-observer.ended
+    if observer.attempts != 15 || stats.Retries != 5 {
+        t.Fatalf("want 15 attempts and 5 retries, got %d and %d", observer.attempts, stats.Retries)
+    }
+}
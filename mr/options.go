@@ -0,0 +1,327 @@
+package mr
+
+import (
+    "runtime"
+    "time"
+)
+
+const (
+    // 默认同时运行的 mapper 协程数
+    defaultWorkers = 16
+    // 最少要有一个 mapper 协程
+    minWorkers = 1
+)
+
+type (
+    // Option 用来定制 MapReduce 的运行参数
+    Option func(opts *mapReduceOptions)
+
+    mapReduceOptions struct {
+        // 同时运行的 mapper 协程数
+        workers int
+        // 存放待处理数据的管道缓冲大小
+        sourceBuffer int
+        // 存放 mapper 结果的管道缓冲大小
+        resultBuffer int
+        // MapReduceByKey 的分区数, 也就是 reducer 协程数
+        reducers int
+        // MapReduceOrdered 最多暂存的结果数, 0 表示按 workers 计算
+        window int
+        // mapper 出错时不停止任务, 最后返回所有的错误
+        continueOnError bool
+        // mapper 出错时的重试策略, nil 表示不重试
+        retry *RetryPolicy
+        // 单个数据的处理时间上限, 0 表示不限制
+        itemTimeout time.Duration
+        // 单个数据超时后怎样处理
+        timeoutPolicy TimeoutPolicy
+        // 整个任务的时间上限, 0 表示不限制
+        jobTimeout time.Duration
+        // 限制 mapper 每秒开始执行的次数, nil 表示不限制
+        limiter *Limiter
+        // 自适应并发的参数, nil 表示使用固定的 workers
+        adaptive *AdaptiveConfig
+        // 推测执行的参数, nil 表示不开启
+        speculation *SpeculationConfig
+        // 定时报告任务进度
+        progress func(Progress)
+        // 多久报告一次进度
+        progressInterval time.Duration
+        // 数据总数, 用来估计完成时间, 0 表示不知道
+        total int64
+        // 任务结束时把统计信息写到这里
+        stats *Stats
+        // 观察任务运行情况的回调
+        observer Observer
+        // 保存处理失败或者被跳过的数据, nil 表示不保存
+        deadLetter DeadLetterSink
+        // 记录处理完的数据, nil 表示不记录
+        checkpoint *checkpointer
+        // 检查点多久写入一次, 小于等于 0 表示只在任务结束时写入
+        checkpointInterval time.Duration
+        // MapReducePriority 最多暂存等待调度的数据个数
+        priorityQueueSize int
+        // MapReducePriority 的数据每等待多久优先级加 1, 0 表示不提升
+        priorityAging time.Duration
+        // MapBatches 每批最多的数据个数
+        batchSize int
+        // MapBatches 一批数据最多等待的时间, 0 表示一直等到攒满
+        batchWait time.Duration
+        // MapReduceCombine 的部分结果合并了多少个数据后交给 reducer, 0 表示不按个数
+        combineItems int
+        // MapReduceCombine 的部分结果最多多久交给 reducer 一次, 0 表示不按时间
+        combineInterval time.Duration
+        // mapper 已经在外层按上面的参数加过超时、重试等功能了, 不用再加
+        decorated bool
+    }
+)
+
+// WithWorkers 设置同时运行的 mapper 协程数, 小于 1 时按 1 处理
+func WithWorkers(n int) Option {
+    return func(opts *mapReduceOptions) {
+        if n < minWorkers {
+            n = minWorkers
+        }
+        opts.workers = n
+    }
+}
+
+// WithSourceBuffer 设置 generate 写入数据的管道缓冲大小, 默认无缓冲
+func WithSourceBuffer(n int) Option {
+    return func(opts *mapReduceOptions) {
+        if n < 0 {
+            n = 0
+        }
+        opts.sourceBuffer = n
+    }
+}
+
+// WithResultBuffer 设置 mapper 写入结果的管道缓冲大小, 默认无缓冲
+func WithResultBuffer(n int) Option {
+    return func(opts *mapReduceOptions) {
+        if n < 0 {
+            n = 0
+        }
+        opts.resultBuffer = n
+    }
+}
+
+// WithReducers 设置 MapReduceByKey 的分区数, 默认是 CPU 核数, 小于 1 时按 1 处理
+func WithReducers(n int) Option {
+    return func(opts *mapReduceOptions) {
+        if n < 1 {
+            n = 1
+        }
+        opts.reducers = n
+    }
+}
+
+// WithReorderWindow 设置 MapReduceOrdered 最多有多少个数据在处理中或者等待排序, 用来限制内存
+// 默认是 workers 的两倍, 小于 workers 时 mapper 跑不满
+func WithReorderWindow(n int) Option {
+    return func(opts *mapReduceOptions) {
+        if n < 1 {
+            n = 1
+        }
+        opts.window = n
+    }
+}
+
+// WithContinueOnError mapper 调用 cancel(err) 或者 panic 时只记录错误, 不停止任务,
+// 所有数据处理完后返回 reducer 的结果和包含所有错误的 *MultiError; generate、reducer 出错依然会停止任务
+func WithContinueOnError() Option {
+    return func(opts *mapReduceOptions) {
+        opts.continueOnError = true
+    }
+}
+
+// WithRetry 设置 mapper 出错时的重试策略, 用尽重试次数后才把错误交给 cancel
+func WithRetry(policy RetryPolicy) Option {
+    return func(opts *mapReduceOptions) {
+        opts.retry = &policy
+    }
+}
+
+// WithItemTimeout 设置 mapper 处理单个数据的时间上限, 开启重试时是每次执行的时间上限
+// 超时后怎样处理由 WithTimeoutPolicy 决定, 默认按 cancel(ErrItemTimeout) 处理
+func WithItemTimeout(d time.Duration) Option {
+    return func(opts *mapReduceOptions) {
+        opts.itemTimeout = d
+    }
+}
+
+// WithTimeoutPolicy 设置单个数据超时后的处理方式
+func WithTimeoutPolicy(policy TimeoutPolicy) Option {
+    return func(opts *mapReduceOptions) {
+        opts.timeoutPolicy = policy
+    }
+}
+
+// WithJobTimeout 设置整个任务的时间上限, 超时后停止所有协程并返回 ErrTimeout
+func WithJobTimeout(d time.Duration) Option {
+    return func(opts *mapReduceOptions) {
+        opts.jobTimeout = d
+    }
+}
+
+// WithRateLimit 限制 mapper 每秒最多开始执行 rps 次, 最多一下子开始 burst 次, rps 小于等于 0 时不限流
+// 要在多个任务之间共用配额时, 用 NewLimiter 创建限流器再通过 WithLimiter 传入
+func WithRateLimit(rps float64, burst int) Option {
+    return WithLimiter(NewLimiter(rps, burst))
+}
+
+// WithLimiter 使用指定的限流器控制 mapper 开始执行的速度
+func WithLimiter(limiter *Limiter) Option {
+    return func(opts *mapReduceOptions) {
+        opts.limiter = limiter
+    }
+}
+
+// WithAdaptiveConcurrency 根据 mapper 的延迟和错误在 config.Min 和 config.Max 之间自动调整并发数, 会覆盖 WithWorkers
+// 运行时的并发数可以通过 WithProgress 的 Progress.Workers 获取, 结束时的并发数可以通过 WithStats 获取
+func WithAdaptiveConcurrency(config AdaptiveConfig) Option {
+    return func(opts *mapReduceOptions) {
+        opts.adaptive = &config
+    }
+}
+
+// WithSpeculation 开启推测执行, 数据执行时间过长时再启动一次执行, 先成功的结果生效, 参数见 SpeculationConfig
+// 开启重试时, 每次重试都会重新计时
+func WithSpeculation(config SpeculationConfig) Option {
+    return func(opts *mapReduceOptions) {
+        opts.speculation = &config
+    }
+}
+
+// WithStats 任务结束时把统计信息写到 stats 里
+func WithStats(stats *Stats) Option {
+    return func(opts *mapReduceOptions) {
+        opts.stats = stats
+    }
+}
+
+// WithProgress 每隔一段时间把任务进度交给 fn, 默认 1 秒一次, 可以通过 WithProgressInterval 修改
+// 任务返回前还会报告一次, 这时 Progress.Done 为 true; fn 在单独的协程里调用, 不要阻塞太久
+func WithProgress(fn func(Progress)) Option {
+    return func(opts *mapReduceOptions) {
+        opts.progress = fn
+    }
+}
+
+// WithProgressInterval 设置多久报告一次进度, 小于等于 0 时按默认的 1 秒处理
+func WithProgressInterval(d time.Duration) Option {
+    return func(opts *mapReduceOptions) {
+        opts.progressInterval = d
+    }
+}
+
+// WithTotal 设置一共有多少个数据, 报告进度时用来估计完成时间
+func WithTotal(n int64) Option {
+    return func(opts *mapReduceOptions) {
+        opts.total = n
+    }
+}
+
+// WithObserver 设置观察任务运行情况的回调, 比如用来接入监控
+func WithObserver(observer Observer) Option {
+    return func(opts *mapReduceOptions) {
+        opts.observer = observer
+    }
+}
+
+// WithDeadLetter mapper 调用 cancel(err)、panic 或者超时的数据写入 sink, 任务继续执行
+// 这些错误不会再返回, 同时开启 WithContinueOnError 时依然会包含在 *MultiError 里
+func WithDeadLetter(sink DeadLetterSink) Option {
+    return func(opts *mapReduceOptions) {
+        opts.deadLetter = sink
+    }
+}
+
+// WithCheckpoint mapper 成功执行完并且结果都被 reducer 读走的数据把 key(item) 记录到 checkpoint 里, 已经记录过的数据直接跳过
+// key 为 nil 时使用 fmt.Sprint(item); 默认每 5 秒写入一次, 可以通过 WithCheckpointInterval 修改
+// 开启后 mapper 写入结果的管道不带缓冲, WithResultBuffer 不起作用; MapReduceOrdered、MapReduceByKey 这些
+// 在 reducer 之前还要整理结果的方法, 结果交给整理的协程就算读走了
+// 任务正常结束时会等所有 mapper 退出, 做最后一次写入; 任务出错时不再写入, 只有 WithContinueOnError 收集的数据错误不算
+func WithCheckpoint(checkpoint Checkpoint, key func(item interface{}) string) Option {
+    return func(opts *mapReduceOptions) {
+        opts.checkpoint = &checkpointer{
+            checkpoint: checkpoint,
+            key:        key,
+        }
+    }
+}
+
+// WithCheckpointInterval 设置检查点多久写入一次, 小于等于 0 时只在任务结束时写入
+func WithCheckpointInterval(d time.Duration) Option {
+    return func(opts *mapReduceOptions) {
+        opts.checkpointInterval = d
+    }
+}
+
+// WithBatch 设置 MapBatches 每批最多 size 个数据, 第一个数据进来后最多等待 maxWait
+// size 小于 1 时按 1 处理, maxWait 为 0 表示一直等到攒满或者没有数据了
+func WithBatch(size int, maxWait time.Duration) Option {
+    return func(opts *mapReduceOptions) {
+        if size < 1 {
+            size = 1
+        }
+        opts.batchSize = size
+        opts.batchWait = maxWait
+    }
+}
+
+// WithPriorityQueue 设置 MapReducePriority 最多暂存 size 个等待调度的数据, 默认 1024, 小于 1 时按 1 处理
+// 数据每等待 aging 优先级加 1, 避免低优先级的数据一直等着, 默认 1 秒, 小于等于 0 时不提升
+func WithPriorityQueue(size int, aging time.Duration) Option {
+    return func(opts *mapReduceOptions) {
+        if size < 1 {
+            size = 1
+        }
+        opts.priorityQueueSize = size
+        opts.priorityAging = aging
+    }
+}
+
+// WithCombineFlush 设置 MapReduceCombine 什么时候把部分结果交给 reducer:
+// 合并了 items 个数据或者距离上次交出超过 interval, 只在 mapper 写完结果后检查
+// 都为 0 时只在所有 mapper 结束后交出
+func WithCombineFlush(items int, interval time.Duration) Option {
+    return func(opts *mapReduceOptions) {
+        opts.combineItems = items
+        opts.combineInterval = interval
+    }
+}
+
+func buildOptions(opts ...Option) *mapReduceOptions {
+    options := newOptions()
+    for _, opt := range opts {
+        opt(options)
+    }
+
+    return options
+}
+
+func newOptions() *mapReduceOptions {
+    return &mapReduceOptions{
+        workers:            defaultWorkers,
+        reducers:           runtime.NumCPU(),
+        batchSize:          defaultBatchSize,
+        priorityQueueSize:  defaultPriorityQueueSize,
+        priorityAging:      defaultPriorityAging,
+        checkpointInterval: defaultCheckpointInterval,
+    }
+}
+
+func (opts *mapReduceOptions) reorderWindow() int {
+    if opts.window > 0 {
+        return opts.window
+    }
+    return opts.workers * 2
+}
+
+// withDecorated 给在外层调用 decorateMapper 的方法用
+func withDecorated() Option {
+    return func(opts *mapReduceOptions) {
+        opts.decorated = true
+    }
+}
@@ -0,0 +1,110 @@
+package mr
+
+import "context"
+
+// 保序的 MapReduce
+// mapper 依然是并发执行的, 但是 reducer 收到结果的顺序和 generate 写入数据的顺序一致
+// 先完成的结果会暂存起来, 等前面的都完成了才交给 reducer, 暂存的数量由 WithReorderWindow 限制
+
+type indexed[T any] struct {
+    seq int
+    val T
+}
+
+func (i indexed[T]) unwrapItem() interface{} {
+    return i.val
+}
+
+// sliceWriter 收集一个 mapper 写入的所有结果
+type sliceWriter[U any] struct {
+    vals []U
+}
+
+func (w *sliceWriter[U]) Writer(val U) {
+    w.vals = append(w.vals, val)
+}
+
+// MapReduceOrdered 和 MapReduceOf 一样, 只是 reducer 按 generate 写入的顺序收到 mapper 的结果
+// 一个 mapper 写入多个结果时, 这些结果按写入顺序排在一起
+func MapReduceOrdered[T, U, V any](ctx context.Context, generate GenerateFuncOf[T], mapper MapperFuncOf[T, U],
+    reducer ReducerFuncOf[U, V], opts ...Option) (V, error) {
+    options := buildOptions(opts...)
+    // 已经发出去但是还没有交给 reducer 的数据个数, 满了 generate 就要等着
+    window := make(chan struct{}, options.reorderWindow())
+    // 超时、重试在这里加, 数据被跳过时外层依然能写入它的位置, 不会卡住后面的数据
+    mapper = decorateMapper(mapper, options)
+    opts = append(opts[:len(opts):len(opts)], withDecorated())
+
+    return MapReduceOf(ctx, func(ctx context.Context, source chan<- indexed[T], cancel func(err error)) {
+        in, stop := innerSource(ctx, generate, cancel)
+        defer stop()
+
+        var seq int
+        for item := range in {
+            select {
+            case <-ctx.Done():
+                return
+            case window <- struct{}{}:
+            }
+            select {
+            case <-ctx.Done():
+                return
+            case source <- indexed[T]{seq: seq, val: item}:
+            }
+            seq++
+        }
+    }, func(ctx context.Context, item indexed[T], writer WriterOf[indexed[[]U]], cancel func(err error)) {
+        // 没有结果或者 panic 了也要写一个, 不然后面的结果都要等着它
+        w := new(sliceWriter[U])
+        defer func() {
+            writer.Writer(indexed[[]U]{seq: item.seq, val: w.vals})
+        }()
+        mapper(ctx, item.val, w, cancel)
+    }, func(ctx context.Context, pipe <-chan indexed[[]U], writer WriterOf[V], cancel func(err error)) {
+        ordered := make(chan U, options.resultBuffer)
+        go reorder(ctx, pipe, ordered, window)
+        reducer(ctx, ordered, writer, cancel)
+    }, opts...)
+}
+
+// MapOrdered 并发执行 mapper, 按 generate 写入的顺序返回所有结果
+func MapOrdered[T, U any](ctx context.Context, generate GenerateFuncOf[T], mapper MapperFuncOf[T, U],
+    opts ...Option) ([]U, error) {
+    return MapReduceOrdered(ctx, generate, mapper, func(ctx context.Context, pipe <-chan U, writer WriterOf[[]U],
+        cancel func(err error)) {
+        var res []U
+        for val := range pipe {
+            res = append(res, val)
+        }
+        writer.Writer(res)
+    }, opts...)
+}
+
+// reorder 把乱序的结果按 seq 排好后写入 ordered, 每交出一个数据就释放一个 window
+func reorder[U any](ctx context.Context, pipe <-chan indexed[[]U], ordered chan<- U, window <-chan struct{}) {
+    defer close(ordered)
+
+    pending := make(map[int][]U)
+    var next int
+    for res := range pipe {
+        pending[res.seq] = res.val
+        for {
+            vals, ok := pending[next]
+            if !ok {
+                break
+            }
+            delete(pending, next)
+            for _, val := range vals {
+                select {
+                case <-ctx.Done():
+                    // reducer 已经不读了, 把剩下的读完让 mapper 退出
+                    drain(pipe)
+                    return
+                case ordered <- val:
+                }
+            }
+            <-window
+            next++
+        }
+    }
+}
@@ -0,0 +1,56 @@
+package mr
+
+import (
+    "context"
+    "math/rand"
+    "testing"
+    "time"
+)
+
+func TestMapOrdered(t *testing.T) {
+    const n = 200
+    res, err := MapOrdered(context.Background(), func(ctx context.Context, source chan<- int, cancel func(err error)) {
+        for i := 0; i < n; i++ {
+            source <- i
+        }
+    }, func(ctx context.Context, item int, writer WriterOf[int], cancel func(err error)) {
+        time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+        if item%3 != 0 {
+            writer.Writer(item)
+        }
+    }, WithWorkers(8), WithReorderWindow(10))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var want int
+    for _, v := range res {
+        for want%3 == 0 {
+            want++
+        }
+        if v != want {
+            t.Fatalf("want %d, got %d", want, v)
+        }
+        want++
+    }
+    if len(res) != n-(n+2)/3 {
+        t.Fatalf("want %d results, got %d", n-(n+2)/3, len(res))
+    }
+}
+
+func TestMapReduceOrderedReducerReturnsEarly(t *testing.T) {
+    res, err := MapReduceOrdered(context.Background(), func(ctx context.Context, source chan<- int, cancel func(err error)) {
+        for i := 0; i < 1000; i++ {
+            source <- i
+        }
+    }, func(ctx context.Context, item int, writer WriterOf[int], cancel func(err error)) {
+        writer.Writer(item)
+    }, func(ctx context.Context, pipe <-chan int, writer WriterOf[int], cancel func(err error)) {
+        writer.Writer(<-pipe)
+    }, WithReorderWindow(4))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res != 0 {
+        t.Fatalf("want 0, got %d", res)
+    }
+}
@@ -0,0 +1,41 @@
+package mr
+
+import (
+    "fmt"
+    "runtime/debug"
+)
+
+// PanicError 表示 generate、mapper、reducer 等用户方法发生了 panic
+// 可以用 errors.As 判断任务是不是因为 panic 结束的
+type PanicError struct {
+    // recover 拿到的值
+    Value interface{}
+    // 发生 panic 的协程堆栈
+    Stack []byte
+}
+
+func (e *PanicError) Error() string {
+    return fmt.Sprintf("panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// Unwrap 如果 panic 的值本身是 error, 让 errors.Is/errors.As 可以继续往下找
+func (e *PanicError) Unwrap() error {
+    if err, ok := e.Value.(error); ok {
+        return err
+    }
+    return nil
+}
+
+func newPanicError(r interface{}) *PanicError {
+    return &PanicError{
+        Value: r,
+        Stack: debug.Stack(),
+    }
+}
+
+// recoverToCancel 要直接 defer 调用, 把 panic 转成错误交给 cancel, 停止整个任务
+func recoverToCancel(cancel func(err error)) {
+    if r := recover(); r != nil {
+        cancel(newPanicError(r))
+    }
+}
@@ -0,0 +1,150 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// 多阶段流水线
+// generate -> 阶段1 -> 阶段2 -> ... -> reducer, 每个阶段都是一组并发的 mapper, 有自己的并发数和缓冲大小
+// 阶段之间通过有缓冲的管道连接, 下游处理不过来时上游会阻塞在写入上
+// 任何一个阶段出错、panic 或者 ctx 被取消, 整个流水线都会停止, 和 MapReduceOf 一样只返回第一个错误
+//
+// 参数按生效的范围分成三类, 传错了地方的参数 RunPipeline 会返回 ErrOptionScope:
+//   - NewPipeline: WithSourceBuffer
+//   - Then: WithWorkers、WithResultBuffer、WithAdaptiveConcurrency、WithItemTimeout、WithTimeoutPolicy、
+//     WithRateLimit、WithLimiter、WithSpeculation、WithRetry、WithCheckpoint、WithCheckpointInterval, 只对这个阶段有效
+//   - RunPipeline: WithJobTimeout、WithContinueOnError、WithDeadLetter、WithObserver、WithStats、
+//     WithProgress、WithProgressInterval、WithTotal, 对所有阶段有效
+// WithReducers、WithReorderWindow 这些只给特定 MapReduce 方法用的参数在流水线里都不起作用
+
+// ErrOptionScope 流水线的参数传给了不起作用的地方
+var ErrOptionScope = errors.New("mapreduce: option has no effect here")
+
+// optionScope 流水线的参数在哪里生效
+type optionScope int
+
+const (
+    // 流水线里不起作用
+    noScope optionScope = iota
+    sourceScope
+    stageScope
+    jobScope
+)
+
+// misplacedOptions 返回 options 里设置了但是在 scope 不起作用的参数
+// 只能和默认值比较, 传入和默认值一样的参数时检查不出来, 不过这时也没有影响
+func misplacedOptions(o *mapReduceOptions, scope optionScope) []string {
+    defaults := newOptions()
+    var names []string
+    check := func(s optionScope, name string, set bool) {
+        if set && s != scope {
+            names = append(names, name)
+        }
+    }
+    check(sourceScope, "WithSourceBuffer", o.sourceBuffer != defaults.sourceBuffer)
+
+    check(stageScope, "WithWorkers", o.workers != defaults.workers)
+    check(stageScope, "WithResultBuffer", o.resultBuffer != defaults.resultBuffer)
+    check(stageScope, "WithAdaptiveConcurrency", o.adaptive != nil)
+    check(stageScope, "WithItemTimeout", o.itemTimeout != defaults.itemTimeout)
+    check(stageScope, "WithTimeoutPolicy", o.timeoutPolicy != defaults.timeoutPolicy)
+    check(stageScope, "WithRateLimit/WithLimiter", o.limiter != nil)
+    check(stageScope, "WithSpeculation", o.speculation != nil)
+    check(stageScope, "WithRetry", o.retry != nil)
+    check(stageScope, "WithCheckpoint", o.checkpoint != nil)
+    check(stageScope, "WithCheckpointInterval", o.checkpointInterval != defaults.checkpointInterval)
+
+    check(jobScope, "WithJobTimeout", o.jobTimeout != defaults.jobTimeout)
+    check(jobScope, "WithContinueOnError", o.continueOnError)
+    check(jobScope, "WithDeadLetter", o.deadLetter != nil)
+    check(jobScope, "WithObserver", o.observer != nil)
+    check(jobScope, "WithStats", o.stats != nil)
+    check(jobScope, "WithProgress", o.progress != nil)
+    check(jobScope, "WithProgressInterval", o.progressInterval != defaults.progressInterval)
+    check(jobScope, "WithTotal", o.total != defaults.total)
+
+    check(noScope, "WithReducers", o.reducers != defaults.reducers)
+    check(noScope, "WithReorderWindow", o.window != defaults.window)
+    check(noScope, "WithBatch", o.batchSize != defaults.batchSize || o.batchWait != defaults.batchWait)
+    check(noScope, "WithPriorityQueue", o.priorityQueueSize != defaults.priorityQueueSize ||
+        o.priorityAging != defaults.priorityAging)
+    check(noScope, "WithCombineFlush", o.combineItems != defaults.combineItems ||
+        o.combineInterval != defaults.combineInterval)
+    return names
+}
+
+// checkScope 有参数传错了地方时返回 ErrOptionScope
+func checkScope(o *mapReduceOptions, scope optionScope, where string) error {
+    if names := misplacedOptions(o, scope); len(names) > 0 {
+        return fmt.Errorf("%w: %s passed to %s", ErrOptionScope, strings.Join(names, ", "), where)
+    }
+    return nil
+}
+
+// Pipeline 保存流水线的各个阶段, T 是最后一个阶段输出的数据类型
+// 同一个 Pipeline 可以多次运行
+type Pipeline[T any] struct {
+    start        func(j *job) <-chan T
+    // 创建流水线时的错误, RunPipeline 时返回
+    err          error
+    // 最后一个阶段开启了检查点, 后面不能再加阶段
+    checkpointed bool
+}
+
+// NewPipeline 创建流水线, opts 里只有 WithSourceBuffer 有效
+func NewPipeline[T any](generate GenerateFuncOf[T], opts ...Option) *Pipeline[T] {
+    options := buildOptions(opts...)
+    return &Pipeline[T]{
+        start: func(j *job) <-chan T {
+            source := make(chan T, options.sourceBuffer)
+            buildSource(j.ctx, generate, source, j.cancel)
+            return source
+        },
+        err: checkScope(options, sourceScope, "NewPipeline"),
+    }
+}
+
+// Then 在 p 后面加一个阶段, 输出的数据类型和输入一样, 比如校验
+func (p *Pipeline[T]) Then(mapper MapperFuncOf[T, T], opts ...Option) *Pipeline[T] {
+    return Then(p, mapper, opts...)
+}
+
+// Then 在 p 后面加一个阶段, 方法不能有类型参数, 所以改变数据类型的阶段要用这个函数加
+// opts 里 WithWorkers、WithResultBuffer、WithAdaptiveConcurrency 设置这个阶段的并发数和输出缓冲, 超时、重试、检查点也只对这个阶段有效
+// 检查点只能加在最后一个阶段上: 加在中间的阶段时, 结果交给下一个阶段就算处理完了, 下一个阶段出错时结果会丢掉
+func Then[T, U any](p *Pipeline[T], mapper MapperFuncOf[T, U], opts ...Option) *Pipeline[U] {
+    options := buildOptions(opts...)
+    mapper = decorateMapper(mapper, options)
+    err := p.err
+    if err == nil {
+        err = checkScope(options, stageScope, "Then")
+    }
+    if err == nil && p.checkpointed {
+        err = fmt.Errorf("%w: WithCheckpoint is only allowed on the last stage", ErrOptionScope)
+    }
+    return &Pipeline[U]{
+        start: func(j *job) <-chan U {
+            return mapStage(j, p.start(j), mapper, options)
+        },
+        err:          err,
+        checkpointed: options.checkpoint != nil,
+    }
+}
+
+// RunPipeline 运行流水线, reducer 归并最后一个阶段的结果
+// opts 里 WithJobTimeout、WithContinueOnError 等对整个任务有效, 参数传错了地方时不运行, 返回 ErrOptionScope
+func RunPipeline[T, V any](ctx context.Context, p *Pipeline[T], reducer ReducerFuncOf[T, V], opts ...Option) (V, error) {
+    options := buildOptions(opts...)
+    err := p.err
+    if err == nil {
+        err = checkScope(options, jobScope, "RunPipeline")
+    }
+    if err != nil {
+        var zero V
+        return zero, err
+    }
+    return runJob(ctx, options, p.start, reducer)
+}
@@ -0,0 +1,100 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "strconv"
+    "testing"
+)
+
+func TestPipeline(t *testing.T) {
+    parsed := Then(NewPipeline(func(ctx context.Context, source chan<- string, cancel func(err error)) {
+        for i := 0; i < 100; i++ {
+            source <- strconv.Itoa(i)
+        }
+    }), func(ctx context.Context, item string, writer WriterOf[int], cancel func(err error)) {
+        n, err := strconv.Atoi(item)
+        if err != nil {
+            cancel(err)
+            return
+        }
+        writer.Writer(n)
+    }, WithWorkers(4), WithResultBuffer(8))
+    validated := parsed.Then(func(ctx context.Context, item int, writer WriterOf[int], cancel func(err error)) {
+        if item%2 == 0 {
+            writer.Writer(item)
+        }
+    }, WithWorkers(2))
+
+    res, err := RunPipeline(context.Background(), validated, func(ctx context.Context, pipe <-chan int,
+        writer WriterOf[int], cancel func(err error)) {
+        var sum int
+        for v := range pipe {
+            sum += v
+        }
+        writer.Writer(sum)
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res != 2450 {
+        t.Fatalf("want 2450, got %d", res)
+    }
+}
+
+func TestPipelineError(t *testing.T) {
+    errDummy := errors.New("dummy")
+    p := NewPipeline(generateInts(1000)).Then(func(ctx context.Context, item int, writer WriterOf[int],
+        cancel func(err error)) {
+        writer.Writer(item)
+    }).Then(func(ctx context.Context, item int, writer WriterOf[int], cancel func(err error)) {
+        if item == 10 {
+            cancel(errDummy)
+        }
+        writer.Writer(item)
+    })
+
+    _, err := RunPipeline(context.Background(), p, countInts)
+    if err != errDummy {
+        t.Fatalf("want %v, got %v", errDummy, err)
+    }
+}
+
+func TestPipelineOptionScope(t *testing.T) {
+    echo := func(ctx context.Context, item int, writer WriterOf[int], cancel func(err error)) {
+        writer.Writer(item)
+    }
+    checkpoint := &countingCheckpoint{done: make(map[string]bool)}
+    tests := map[string]struct {
+        pipeline *Pipeline[int]
+        opts     []Option
+    }{
+        "stage option on source": {pipeline: NewPipeline(generateInts(10), WithWorkers(2)).Then(echo)},
+        "job option on stage":    {pipeline: NewPipeline(generateInts(10)).Then(echo, WithContinueOnError())},
+        "stage option on job": {
+            pipeline: NewPipeline(generateInts(10)).Then(echo),
+            opts:     []Option{WithRetry(RetryPolicy{MaxAttempts: 2})},
+        },
+        "unsupported option": {
+            pipeline: NewPipeline(generateInts(10)).Then(echo),
+            opts:     []Option{WithReducers(3)},
+        },
+        "checkpoint before last stage": {
+            pipeline: NewPipeline(generateInts(10)).Then(echo, WithCheckpoint(checkpoint, nil)).Then(echo),
+        },
+    }
+    for name, test := range tests {
+        t.Run(name, func(t *testing.T) {
+            _, err := RunPipeline(context.Background(), test.pipeline, countInts, test.opts...)
+            if !errors.Is(err, ErrOptionScope) {
+                t.Fatalf("want %v, got %v", ErrOptionScope, err)
+            }
+        })
+    }
+
+    res, err := RunPipeline(context.Background(), NewPipeline(generateInts(10), WithSourceBuffer(4)).
+        Then(echo, WithWorkers(2), WithCheckpoint(checkpoint, nil)), countInts, WithContinueOnError())
+    if err != nil || res != 10 {
+        t.Fatalf("want 10 and no error, got %d and %v", res, err)
+    }
+}
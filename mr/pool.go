@@ -0,0 +1,67 @@
+package mr
+
+import "sync"
+
+// workerPool 控制同时运行的 mapper 协程数, 和带缓冲的管道作用一样, 只是上限可以在运行时调整
+type workerPool struct {
+    lock   sync.Mutex
+    limit  int
+    active int
+    // 有协程退出或者上限变大时通知等待的一方, 只有 executeMappers 一个协程在等
+    wake   chan struct{}
+}
+
+func newWorkerPool(limit int) *workerPool {
+    return &workerPool{
+        limit: limit,
+        wake:  make(chan struct{}, 1),
+    }
+}
+
+// acquire 拿到一个位置返回 true, done 被关闭时返回 false
+func (p *workerPool) acquire(done <-chan struct{}) bool {
+    for {
+        p.lock.Lock()
+        if p.active < p.limit {
+            p.active++
+            p.lock.Unlock()
+            return true
+        }
+        p.lock.Unlock()
+
+        select {
+        case <-done:
+            return false
+        case <-p.wake:
+        }
+    }
+}
+
+func (p *workerPool) release() {
+    p.lock.Lock()
+    p.active--
+    p.lock.Unlock()
+    p.notify()
+}
+
+// setLimit 调整上限, 变小时已经在运行的协程不受影响, 等它们退出后才会降下来
+func (p *workerPool) setLimit(limit int) {
+    p.lock.Lock()
+    p.limit = limit
+    p.lock.Unlock()
+    p.notify()
+}
+
+// saturated 所有位置都被占用了
+func (p *workerPool) saturated() bool {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    return p.active >= p.limit
+}
+
+func (p *workerPool) notify() {
+    select {
+    case p.wake <- struct{}{}:
+    default:
+    }
+}
@@ -0,0 +1,137 @@
+package mr
+
+import (
+    "container/heap"
+    "context"
+    "time"
+)
+
+// 按优先级调度
+// generate 给每个数据带上优先级, 有空闲的 mapper 协程时总是先交出优先级最高的数据, 优先级相同时先进先出
+// 为了不让低优先级的数据一直等着, 数据每等待一段时间(WithPriorityQueue 的 aging)优先级就加 1
+
+const (
+    // 默认最多暂存等待调度的数据个数
+    defaultPriorityQueueSize = 1024
+    // 默认数据每等待多久优先级加 1
+    defaultPriorityAging = time.Second
+)
+
+type (
+    // Prioritized 带优先级的数据, Priority 越大越先处理
+    Prioritized[T any] struct {
+        Item     T
+        Priority int
+    }
+
+    // PriorityGenerateFuncOf 和 GenerateFuncOf 一样, 只是写入的数据带有优先级
+    PriorityGenerateFuncOf[T any] func(ctx context.Context, source chan<- Prioritized[T], cancel func(err error))
+)
+
+// MapReducePriority 和 MapReduceOf 一样, 只是有空闲的 mapper 协程时先处理优先级高的数据
+// 最多暂存多少个数据、低优先级数据多久提升一次优先级通过 WithPriorityQueue 设置, WithSourceBuffer 不起作用
+func MapReducePriority[T, U, V any](ctx context.Context, generate PriorityGenerateFuncOf[T], mapper MapperFuncOf[T, U],
+    reducer ReducerFuncOf[U, V], opts ...Option) (V, error) {
+    options := buildOptions(opts...)
+    // source 有缓冲的话, 缓冲里的数据就只能先进先出了
+    opts = append(opts[:len(opts):len(opts)], WithSourceBuffer(0))
+
+    return MapReduceOf(ctx, func(ctx context.Context, source chan<- T, cancel func(err error)) {
+        in, stop := innerSource(ctx, GenerateFuncOf[Prioritized[T]](generate), cancel)
+        defer stop()
+
+        schedule(ctx, in, source, options.priorityQueueSize, options.priorityAging)
+    }, mapper, reducer, opts...)
+}
+
+// prioritizedItem 等待调度的数据
+type prioritizedItem[T any] struct {
+    item T
+    // 排序用的优先级, 加上了等待时间的提升
+    rank float64
+    seq  int
+}
+
+// priorityQueue 按 rank 从大到小, rank 相同时按 seq 从小到大排序
+type priorityQueue[T any] []prioritizedItem[T]
+
+func (q priorityQueue[T]) Len() int {
+    return len(q)
+}
+
+func (q priorityQueue[T]) Less(i, j int) bool {
+    if q[i].rank != q[j].rank {
+        return q[i].rank > q[j].rank
+    }
+    return q[i].seq < q[j].seq
+}
+
+func (q priorityQueue[T]) Swap(i, j int) {
+    q[i], q[j] = q[j], q[i]
+}
+
+func (q *priorityQueue[T]) Push(x interface{}) {
+    *q = append(*q, x.(prioritizedItem[T]))
+}
+
+func (q *priorityQueue[T]) Pop() interface{} {
+    old := *q
+    n := len(old)
+    item := old[n-1]
+    *q = old[:n-1]
+    return item
+}
+
+// schedule 从 in 里读取数据暂存起来, 每次有 mapper 协程来读取时交出优先级最高的
+// source 是无缓冲的, 能写进去说明有空闲的 mapper 协程
+func schedule[T any](ctx context.Context, in <-chan Prioritized[T], source chan<- T, size int,
+    aging time.Duration) {
+    var (
+        queue priorityQueue[T]
+        seq   int
+        start = time.Now()
+    )
+    for in != nil || len(queue) > 0 {
+        // 暂存满了就不再读取, 让 generate 等着
+        recv := in
+        if len(queue) >= size {
+            recv = nil
+        }
+        // 没有数据时不写入
+        var (
+            send chan<- T
+            next T
+        )
+        if len(queue) > 0 {
+            send = source
+            next = queue[0].item
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case p, ok := <-recv:
+            if !ok {
+                in = nil
+                continue
+            }
+            heap.Push(&queue, prioritizedItem[T]{
+                item: p.Item,
+                rank: priorityRank(p.Priority, time.Since(start), aging),
+                seq:  seq,
+            })
+            seq++
+        case send <- next:
+            heap.Pop(&queue)
+        }
+    }
+}
+
+// priorityRank 数据等待 aging 后优先级加 1, 也就是在 now 时的优先级是 priority + (now - enqueued) / aging
+// 减掉所有数据都一样的 now, 排序用的值就不会随时间变化了
+func priorityRank(priority int, enqueued, aging time.Duration) float64 {
+    if aging <= 0 {
+        return float64(priority)
+    }
+    return float64(priority) - float64(enqueued)/float64(aging)
+}
@@ -0,0 +1,72 @@
+package mr
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestMapReducePriority(t *testing.T) {
+    // 一个 mapper 协程, 第一个数据处理完时其它数据都已经在排队了
+    res, err := runPriority(t, []Prioritized[int]{
+        {Item: 1, Priority: 10},
+        {Item: 2, Priority: 0},
+        {Item: 3, Priority: 5},
+        {Item: 4, Priority: 1},
+        {Item: 5, Priority: 5},
+    }, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []int{1, 3, 5, 4, 2}
+    for i := range want {
+        if res[i] != want[i] {
+            t.Fatalf("want %v, got %v", want, res)
+        }
+    }
+}
+
+func TestMapReducePriorityAging(t *testing.T) {
+    // 提升得很快, 先进来的低优先级数据等一会儿就排到前面了
+    res, err := runPriority(t, []Prioritized[int]{
+        {Item: 1, Priority: 10},
+        {Item: 2, Priority: 0},
+        {Item: 3, Priority: 0},
+        {Item: 4, Priority: 1},
+    }, time.Nanosecond)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []int{1, 2, 3, 4}
+    for i := range want {
+        if res[i] != want[i] {
+            t.Fatalf("want %v, got %v", want, res)
+        }
+    }
+}
+
+// runPriority 用一个 mapper 协程处理 items, 按处理的顺序返回
+func runPriority(t *testing.T, items []Prioritized[int], aging time.Duration) ([]int, error) {
+    t.Helper()
+    queued := make(chan struct{})
+    return MapReducePriority(context.Background(), func(ctx context.Context, source chan<- Prioritized[int],
+        cancel func(err error)) {
+        for _, item := range items {
+            source <- item
+        }
+        close(queued)
+    }, func(ctx context.Context, item int, writer WriterOf[int], cancel func(err error)) {
+        if item == 1 {
+            // 等所有数据都进入队列
+            <-queued
+            time.Sleep(10 * time.Millisecond)
+        }
+        writer.Writer(item)
+    }, func(ctx context.Context, pipe <-chan int, writer WriterOf[[]int], cancel func(err error)) {
+        var res []int
+        for v := range pipe {
+            res = append(res, v)
+        }
+        writer.Writer(res)
+    }, WithWorkers(1), WithPriorityQueue(10, aging))
+}
@@ -0,0 +1,99 @@
+package mr
+
+import (
+    "sync/atomic"
+    "time"
+)
+
+const (
+    // 默认多久报告一次进度
+    defaultProgressInterval = time.Second
+)
+
+// Progress 任务的进度, 通过 WithProgress 定时获取
+// 计数只统计第一个 mapper 阶段, Pipeline 后面的阶段不算在里面
+type Progress struct {
+    // 当前 mapper 的并发数上限, 开启 WithAdaptiveConcurrency 时会随着运行变化
+    Workers int
+    // 从 generate 拿到的数据个数
+    Generated int64
+    // mapper 成功执行完的数据个数
+    Completed int64
+    // mapper 调用 cancel 或者 panic 的数据个数
+    Failed int64
+    // WithTotal 设置的数据总数, 0 表示不知道
+    Total int64
+    // 任务已经运行的时间
+    Elapsed time.Duration
+    // 平均每秒处理完(包括失败)的数据个数
+    Throughput float64
+    // 按 Throughput 估计的完成时间, 不知道 Total 或者还没有数据处理完时是零值
+    ETA time.Time
+    // 任务已经结束了, 这是最后一次报告
+    Done bool
+}
+
+// Remaining 估计还要多久完成, 估计不出来时返回 0
+func (p Progress) Remaining() time.Duration {
+    if p.ETA.IsZero() {
+        return 0
+    }
+    return time.Until(p.ETA)
+}
+
+// progress 根据当前的计数计算进度
+func (j *job) progress(now time.Time) Progress {
+    p := Progress{
+        Workers:   int(atomic.LoadInt64(&j.stats.workers)),
+        Generated: atomic.LoadInt64(&j.stats.generated),
+        Completed: atomic.LoadInt64(&j.stats.firstMapped),
+        Failed:    atomic.LoadInt64(&j.stats.firstFailed),
+        Total:     j.options.total,
+        Elapsed:   now.Sub(j.start),
+    }
+    finished := p.Completed + p.Failed
+    if finished == 0 || p.Elapsed <= 0 {
+        return p
+    }
+    p.Throughput = float64(finished) / p.Elapsed.Seconds()
+    if p.Total > 0 {
+        remaining := p.Total - finished
+        if remaining < 0 {
+            remaining = 0
+        }
+        p.ETA = now.Add(time.Duration(float64(remaining) / p.Throughput * float64(time.Second)))
+    }
+    return p
+}
+
+// reportProgress 启动定时报告进度的协程, 返回的方法停止定时报告, 再报告最后一次
+func (j *job) reportProgress() (stop func()) {
+    interval := j.options.progressInterval
+    if interval <= 0 {
+        interval = defaultProgressInterval
+    }
+
+    quit := make(chan struct{})
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-quit:
+                return
+            case now := <-ticker.C:
+                j.options.progress(j.progress(now))
+            }
+        }
+    }()
+
+    return func() {
+        close(quit)
+        <-stopped
+        p := j.progress(time.Now())
+        p.Done = true
+        j.options.progress(p)
+    }
+}
@@ -0,0 +1,59 @@
+package mr
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestMapReduceWithProgress(t *testing.T) {
+    var (
+        lock    sync.Mutex
+        reports []Progress
+    )
+    res, err := MapReduceOf(context.Background(), generateInts(50), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        time.Sleep(time.Millisecond)
+        if item%10 == 0 {
+            cancel(ErrItemTimeout)
+            return
+        }
+        writer.Writer(item)
+    }, countInts, WithWorkers(2), WithContinueOnError(), WithTotal(50), WithProgress(func(p Progress) {
+        lock.Lock()
+        reports = append(reports, p)
+        lock.Unlock()
+    }), WithProgressInterval(5*time.Millisecond))
+    if err == nil {
+        t.Fatal("want errors")
+    }
+    if res != 45 {
+        t.Fatalf("want 45, got %d", res)
+    }
+
+    lock.Lock()
+    defer lock.Unlock()
+    if len(reports) < 2 {
+        t.Fatalf("want periodic reports, got %d", len(reports))
+    }
+    var eta bool
+    for _, p := range reports[:len(reports)-1] {
+        if p.Done {
+            t.Fatal("only the last report should be done")
+        }
+        if !p.ETA.IsZero() {
+            eta = true
+        }
+    }
+    if !eta {
+        t.Fatal("want an estimated completion time")
+    }
+    last := reports[len(reports)-1]
+    if !last.Done || last.Generated != 50 || last.Completed != 45 || last.Failed != 5 || last.Total != 50 {
+        t.Fatalf("unexpected last report: %+v", last)
+    }
+    if last.Throughput <= 0 {
+        t.Fatalf("want throughput, got %v", last.Throughput)
+    }
+}
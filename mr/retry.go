@@ -0,0 +1,126 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "math"
+    "math/rand"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    // 默认退避时间的增长倍数
+    defaultBackoffMultiplier = 2
+)
+
+// RetryPolicy 定义 mapper 出错(调用 cancel(err))后怎样重试
+// panic 不会重试, cancel(nil) 依然会直接停止任务
+type RetryPolicy struct {
+    // 最多执行的次数, 包含第一次, 小于等于 1 时不重试
+    MaxAttempts int
+    // 第一次重试前等待的时间, 之后每次乘以 Multiplier
+    InitialBackoff time.Duration
+    // 等待时间的上限, 0 表示不限制
+    MaxBackoff time.Duration
+    // 等待时间的增长倍数, 小于 1 时按 2 处理
+    Multiplier float64
+    // 在等待时间上随机加减的比例, 取值 0~1, 避免大量重试同时发生
+    Jitter float64
+    // 判断错误是否需要重试, nil 表示所有错误都重试
+    Retryable func(err error) bool
+    // 每次重试前调用, attempt 是刚刚失败的是第几次执行, 可以用来做统计
+    OnRetry func(item interface{}, attempt int, err error)
+}
+
+type attemptKey struct{}
+
+// Attempt 返回 mapper 当前是第几次执行这个数据, 从 1 开始, 没有开启重试时总是 1
+func Attempt(ctx context.Context) int {
+    if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+        return attempt
+    }
+    return 1
+}
+
+// backoff 返回第 attempt 次失败后要等待的时间
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+    multiplier := p.Multiplier
+    if multiplier < 1 {
+        multiplier = defaultBackoffMultiplier
+    }
+    delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+    if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+        delay = float64(p.MaxBackoff)
+    }
+    if p.Jitter > 0 {
+        delay *= 1 + p.Jitter*(rand.Float64()*2-1)
+    }
+    if delay < 0 {
+        return 0
+    }
+    return time.Duration(delay)
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+    return p.Retryable == nil || p.Retryable(err)
+}
+
+// retryMapper 给 mapper 加上重试
+// 每次执行的结果先暂存起来, 成功了才写给 reducer, 避免失败的那次写入一半的结果
+func retryMapper[T, U any](mapper MapperFuncOf[T, U], policy *RetryPolicy) MapperFuncOf[T, U] {
+    return func(ctx context.Context, item T, writer WriterOf[U], cancel func(err error)) {
+        state := itemStateFromContext(ctx)
+        for attempt := 1; ; attempt++ {
+            if state != nil {
+                state.setAttempt(attempt)
+            }
+            var (
+                lock     sync.Mutex
+                canceled bool
+                err      error
+            )
+            w := new(sliceWriter[U])
+            mapper(context.WithValue(ctx, attemptKey{}, attempt), item, w, func(e error) {
+                lock.Lock()
+                defer lock.Unlock()
+                if !canceled {
+                    canceled = true
+                    err = e
+                }
+            })
+
+            lock.Lock()
+            failed, err := canceled, err
+            lock.Unlock()
+            if !failed {
+                for _, val := range w.vals {
+                    writer.Writer(val)
+                }
+                return
+            }
+            // 超时、推测执行会在别的协程里执行 mapper, panic 在那里被转成了错误, 同样不重试
+            var panicErr *PanicError
+            if err == nil || errors.As(err, &panicErr) || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+                cancel(err)
+                return
+            }
+
+            if policy.OnRetry != nil {
+                policy.OnRetry(item, attempt, err)
+            }
+            if stats := statsFromContext(ctx); stats != nil {
+                atomic.AddInt64(&stats.retries, 1)
+            }
+            timer := time.NewTimer(policy.backoff(attempt))
+            select {
+            case <-ctx.Done():
+                // 任务已经结束了, 不用再重试
+                timer.Stop()
+                return
+            case <-timer.C:
+            }
+        }
+    }
+}
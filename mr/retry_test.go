@@ -0,0 +1,110 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestMapReduceWithRetry(t *testing.T) {
+    errFlaky := errors.New("flaky")
+    var retries int32
+    res, err := MapReduceOf(context.Background(), func(ctx context.Context, source chan<- int, cancel func(err error)) {
+        for i := 0; i < 5; i++ {
+            source <- i
+        }
+    }, func(ctx context.Context, item int, writer WriterOf[int], cancel func(err error)) {
+        writer.Writer(item)
+        if Attempt(ctx) < 3 {
+            cancel(errFlaky)
+        }
+    }, func(ctx context.Context, pipe <-chan int, writer WriterOf[int], cancel func(err error)) {
+        var n int
+        for range pipe {
+            n++
+        }
+        writer.Writer(n)
+    }, WithRetry(RetryPolicy{
+        MaxAttempts:    3,
+        InitialBackoff: time.Millisecond,
+        Jitter:         0.5,
+        OnRetry: func(item interface{}, attempt int, err error) {
+            atomic.AddInt32(&retries, 1)
+        },
+    }))
+    if err != nil {
+        t.Fatal(err)
+    }
+    // 失败的那次写入的结果不会交给 reducer
+    if res != 5 {
+        t.Fatalf("want 5, got %d", res)
+    }
+    if retries != 10 {
+        t.Fatalf("want 10 retries, got %d", retries)
+    }
+}
+
+func TestMapReduceWithRetryGiveUp(t *testing.T) {
+    errFatal := errors.New("fatal")
+    var attempts int32
+    err := FinishWithOptions(context.Background(), []func(ctx context.Context) error{
+        func(ctx context.Context) error {
+            atomic.AddInt32(&attempts, 1)
+            return errFatal
+        },
+    }, WithRetry(RetryPolicy{
+        MaxAttempts: 5,
+        Retryable: func(err error) bool {
+            return err != errFatal
+        },
+    }))
+    if err != errFatal {
+        t.Fatalf("want %v, got %v", errFatal, err)
+    }
+    if attempts != 1 {
+        t.Fatalf("want 1 attempt, got %d", attempts)
+    }
+}
+
+func TestMapReduceWithRetryCanceled(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+
+    start := time.Now()
+    err := FinishWithOptions(ctx, []func(ctx context.Context) error{
+        func(ctx context.Context) error {
+            return errors.New("always")
+        },
+    }, WithRetry(RetryPolicy{
+        MaxAttempts:    10,
+        InitialBackoff: time.Hour,
+    }))
+    if err != context.DeadlineExceeded {
+        t.Fatalf("want %v, got %v", context.DeadlineExceeded, err)
+    }
+    if time.Since(start) > time.Second {
+        t.Fatal("retry backoff should stop when the job is canceled")
+    }
+}
+
+func TestMapReduceWithRetryPanicInTimeout(t *testing.T) {
+    var attempts int32
+    // 超时在别的协程里执行 mapper, panic 被转成了错误, 依然不能重试
+    _, err := MapReduceOf(context.Background(), generateInts(10), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        if item == 5 {
+            atomic.AddInt32(&attempts, 1)
+            panic("retry")
+        }
+        writer.Writer(item)
+    }, countInts, WithItemTimeout(time.Second), WithRetry(RetryPolicy{MaxAttempts: 3}))
+    var pe *PanicError
+    if !errors.As(err, &pe) {
+        t.Fatalf("want *PanicError, got %v", err)
+    }
+    if n := atomic.LoadInt32(&attempts); n != 1 {
+        t.Fatalf("want 1 attempt, got %d", n)
+    }
+}
@@ -0,0 +1,113 @@
+package mr
+
+import (
+    "context"
+    "sync"
+)
+
+// 按 key 分组的 MapReduce
+// mapper 输出 (key, value), 框架把每个新出现的 key 轮流分到 reducers 个分区里,
+// 每个分区一个协程, 同一个 key 的所有 value 会交给同一个分区的 reducer 处理
+
+type (
+    // mapper 用它输出 (key, value)
+    KeyedWriterOf[K comparable, U any] interface {
+        Writer(key K, val U)
+    }
+    // item 是要处理的数据, writer 用来输出 (key, value), cancel 如果执行有错误, 可以调用它,停止后续执行
+    KeyedMapperFuncOf[T any, K comparable, U any] func(ctx context.Context, item T, writer KeyedWriterOf[K, U], cancel func(err error))
+    // values 是这个 key 的所有 value, 返回这个 key 归并后的结果, 返回错误会停止整个任务
+    KeyedReducerFuncOf[K comparable, U, V any] func(ctx context.Context, key K, values []U) (V, error)
+)
+
+type keyedPair[K comparable, U any] struct {
+    key K
+    val U
+}
+
+type keyedWriter[K comparable, U any] struct {
+    writer WriterOf[keyedPair[K, U]]
+}
+
+func (w keyedWriter[K, U]) Writer(key K, val U) {
+    w.writer.Writer(keyedPair[K, U]{key: key, val: val})
+}
+
+// MapReduceByKey 按 key 分组归并, 返回 key 到归并结果的 map
+// 分区数通过 WithReducers 设置
+func MapReduceByKey[T any, K comparable, U, V any](ctx context.Context, generate GenerateFuncOf[T],
+    mapper KeyedMapperFuncOf[T, K, U], reducer KeyedReducerFuncOf[K, U, V], opts ...Option) (map[K]V, error) {
+    options := buildOptions(opts...)
+    return MapReduceOf(ctx, generate, func(ctx context.Context, item T, writer WriterOf[keyedPair[K, U]], cancel func(err error)) {
+        mapper(ctx, item, keyedWriter[K, U]{writer: writer}, cancel)
+    }, func(ctx context.Context, pipe <-chan keyedPair[K, U], writer WriterOf[map[K]V], cancel func(err error)) {
+        shuffle(ctx, pipe, writer, cancel, reducer, options)
+    }, opts...)
+}
+
+func shuffle[K comparable, U, V any](ctx context.Context, pipe <-chan keyedPair[K, U], writer WriterOf[map[K]V],
+    cancel func(err error), reducer KeyedReducerFuncOf[K, U, V], options *mapReduceOptions) {
+    partitions := make([]chan keyedPair[K, U], options.reducers)
+    results := make([]map[K]V, options.reducers)
+    var wg sync.WaitGroup
+    for i := range partitions {
+        partitions[i] = make(chan keyedPair[K, U], options.resultBuffer)
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            // 出错后也要把分区里的数据读完, 不然分发数据的协程会卡住
+            defer drain(partitions[i])
+            defer recoverToCancel(cancel)
+
+            groups := make(map[K][]U)
+            for pair := range partitions[i] {
+                groups[pair.key] = append(groups[pair.key], pair.val)
+            }
+            res := make(map[K]V, len(groups))
+            for key, values := range groups {
+                if ctx.Err() != nil {
+                    return
+                }
+                val, err := reducer(ctx, key, values)
+                if err != nil {
+                    cancel(err)
+                    return
+                }
+                res[key] = val
+            }
+            results[i] = res
+        }(i)
+    }
+
+    // 按 key 分发到各个分区, 只有这一个协程分发, 新的 key 轮流分配分区, 记下来以后同一个 key 都发到这里
+    // 不能用 key 打印出来的字符串做哈希, 相等的 key 打印出来可能不一样, 比如 0.0 和 -0.0
+    assigned := make(map[K]int)
+    for pair := range pipe {
+        i, ok := assigned[pair.key]
+        if !ok {
+            i = len(assigned) % len(partitions)
+            assigned[pair.key] = i
+        }
+        partitions[i] <- pair
+    }
+    for _, partition := range partitions {
+        close(partition)
+    }
+    wg.Wait()
+    // 任务已经结束了, 结果不完整, 不用再写了
+    if ctx.Err() != nil {
+        return
+    }
+
+    var size int
+    for _, res := range results {
+        size += len(res)
+    }
+    merged := make(map[K]V, size)
+    for _, res := range results {
+        for key, val := range res {
+            merged[key] = val
+        }
+    }
+    writer.Writer(merged)
+}
@@ -0,0 +1,75 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "math"
+    "strings"
+    "testing"
+)
+
+func TestMapReduceByKey(t *testing.T) {
+    lines := []string{"a b c", "b c", "c"}
+    res, err := MapReduceByKey(context.Background(), func(ctx context.Context, source chan<- string, cancel func(err error)) {
+        for _, line := range lines {
+            source <- line
+        }
+    }, func(ctx context.Context, line string, writer KeyedWriterOf[string, int], cancel func(err error)) {
+        for _, word := range strings.Fields(line) {
+            writer.Writer(word, 1)
+        }
+    }, func(ctx context.Context, key string, values []int) (int, error) {
+        var sum int
+        for _, v := range values {
+            sum += v
+        }
+        return sum, nil
+    }, WithReducers(2))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := map[string]int{"a": 1, "b": 2, "c": 3}
+    if len(res) != len(want) {
+        t.Fatalf("want %v, got %v", want, res)
+    }
+    for k, v := range want {
+        if res[k] != v {
+            t.Fatalf("want %v, got %v", want, res)
+        }
+    }
+}
+
+func TestMapReduceByKeyError(t *testing.T) {
+    errDummy := errors.New("dummy")
+    _, err := MapReduceByKey(context.Background(), func(ctx context.Context, source chan<- int, cancel func(err error)) {
+        for i := 0; i < 100; i++ {
+            source <- i
+        }
+    }, func(ctx context.Context, item int, writer KeyedWriterOf[int, int], cancel func(err error)) {
+        writer.Writer(item%10, item)
+    }, func(ctx context.Context, key int, values []int) (int, error) {
+        if key == 3 {
+            return 0, errDummy
+        }
+        return len(values), nil
+    })
+    if err != errDummy {
+        t.Fatalf("want %v, got %v", errDummy, err)
+    }
+}
+
+func TestMapReduceByKeyEqualKeys(t *testing.T) {
+    // 0.0 和 -0.0 相等, 但是打印出来不一样, 要分到同一个分区
+    res, err := MapReduceByKey(context.Background(), FromSlice([]float64{0, math.Copysign(0, -1)}),
+        func(ctx context.Context, item float64, writer KeyedWriterOf[float64, int], cancel func(err error)) {
+            writer.Writer(item, 1)
+        }, func(ctx context.Context, key float64, values []int) (int, error) {
+            return len(values), nil
+        }, WithReducers(4))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res) != 1 || res[0] != 2 {
+        t.Fatalf("want map[0:2], got %v", res)
+    }
+}
@@ -0,0 +1,99 @@
+package mr
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "io"
+)
+
+// 常用数据源的 generate, 任务结束后马上停止读取, 读取出错时调用 cancel(err) 停止任务
+
+// FromSlice 按顺序把 items 写入 source
+func FromSlice[T any](items []T) GenerateFuncOf[T] {
+    return func(ctx context.Context, source chan<- T, cancel func(err error)) {
+        for _, item := range items {
+            if !sendItem(ctx, source, item) {
+                return
+            }
+        }
+    }
+}
+
+// FromChan 把 ch 里的数据写入 source, 直到 ch 被关闭
+// 任务提前结束时 ch 里剩下的数据不会被读取, 写入 ch 的一方要自己处理退出
+func FromChan[T any](ch <-chan T) GenerateFuncOf[T] {
+    return func(ctx context.Context, source chan<- T, cancel func(err error)) {
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case item, ok := <-ch:
+                if !ok || !sendItem(ctx, source, item) {
+                    return
+                }
+            }
+        }
+    }
+}
+
+// FromLines 把 r 里的每一行作为一个数据, 不包含行尾的换行符, 单行最长 bufio.MaxScanTokenSize
+func FromLines(r io.Reader) GenerateFuncOf[string] {
+    return func(ctx context.Context, source chan<- string, cancel func(err error)) {
+        scanner := bufio.NewScanner(r)
+        for scanner.Scan() {
+            if !sendItem(ctx, source, scanner.Text()) {
+                return
+            }
+        }
+        if err := scanner.Err(); err != nil {
+            cancel(err)
+        }
+    }
+}
+
+// FromJSONLines 把 r 里的每个 json 值解析成 T 作为一个数据, 一般是一行一个
+func FromJSONLines[T any](r io.Reader) GenerateFuncOf[T] {
+    return func(ctx context.Context, source chan<- T, cancel func(err error)) {
+        decoder := json.NewDecoder(r)
+        for {
+            var item T
+            if err := decoder.Decode(&item); err != nil {
+                if err != io.EOF {
+                    cancel(err)
+                }
+                return
+            }
+            if !sendItem(ctx, source, item) {
+                return
+            }
+        }
+    }
+}
+
+// FromFunc 不断调用 next 拿到数据, next 返回 false 表示没有数据了, 返回错误时停止任务
+// 可以用来适配各种迭代器, 比如数据库游标、分页接口
+func FromFunc[T any](next func() (T, bool, error)) GenerateFuncOf[T] {
+    return func(ctx context.Context, source chan<- T, cancel func(err error)) {
+        for ctx.Err() == nil {
+            item, ok, err := next()
+            if err != nil {
+                cancel(err)
+                return
+            }
+            if !ok || !sendItem(ctx, source, item) {
+                return
+            }
+        }
+    }
+}
+
+// sendItem 把 item 写入 source, 任务结束时返回 false
+func sendItem[T any](ctx context.Context, source chan<- T, item T) bool {
+    select {
+    case <-ctx.Done():
+        return false
+    case source <- item:
+        return true
+    }
+}
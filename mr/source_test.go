@@ -0,0 +1,87 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+)
+
+func sumInts(ctx context.Context, pipe <-chan int, writer WriterOf[int], cancel func(err error)) {
+    var sum int
+    for v := range pipe {
+        sum += v
+    }
+    writer.Writer(sum)
+}
+
+func identity[T any](ctx context.Context, item T, writer WriterOf[T], cancel func(err error)) {
+    writer.Writer(item)
+}
+
+func TestSources(t *testing.T) {
+    ch := make(chan int, 3)
+    ch <- 1
+    ch <- 2
+    ch <- 3
+    close(ch)
+
+    var n int
+    tests := map[string]GenerateFuncOf[int]{
+        "slice": FromSlice([]int{1, 2, 3}),
+        "chan":  FromChan(ch),
+        "json":  FromJSONLines[int](strings.NewReader("1\n2\n3\n")),
+        "func": FromFunc(func() (int, bool, error) {
+            n++
+            return n, n <= 3, nil
+        }),
+    }
+    for name, generate := range tests {
+        res, err := MapReduceOf(context.Background(), generate, identity[int], sumInts)
+        if err != nil {
+            t.Fatalf("%s: %v", name, err)
+        }
+        if res != 6 {
+            t.Fatalf("%s: want 6, got %d", name, res)
+        }
+    }
+
+    res, err := MapOrdered(context.Background(), FromLines(strings.NewReader("a\nb\nc")), identity[string])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if strings.Join(res, ",") != "a,b,c" {
+        t.Fatalf("want a,b,c, got %v", res)
+    }
+}
+
+func TestSourceErrors(t *testing.T) {
+    errDummy := errors.New("dummy")
+    _, err := MapReduceOf(context.Background(), FromFunc(func() (int, bool, error) {
+        return 0, false, errDummy
+    }), identity[int], sumInts)
+    if err != errDummy {
+        t.Fatalf("want %v, got %v", errDummy, err)
+    }
+
+    _, err = MapReduceOf(context.Background(), FromJSONLines[int](strings.NewReader("1\nx\n")), identity[int], sumInts)
+    if err == nil {
+        t.Fatal("want json error")
+    }
+}
+
+func TestSourceStopsWhenJobDone(t *testing.T) {
+    var calls int
+    errDummy := errors.New("dummy")
+    _, err := MapReduceOf(context.Background(), FromFunc(func() (int, bool, error) {
+        calls++
+        return calls, true, nil
+    }), func(ctx context.Context, item int, writer WriterOf[int], cancel func(err error)) {
+        if item == 10 {
+            cancel(errDummy)
+        }
+    }, sumInts)
+    if err != errDummy {
+        t.Fatalf("want %v, got %v", errDummy, err)
+    }
+}
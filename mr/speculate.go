@@ -0,0 +1,202 @@
+package mr
+
+import (
+    "context"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// 推测执行: 和 MapReduce 的 backup task 一样, 一个数据执行的时间明显比其它数据长时,
+// 再启动一次执行, 哪次先成功就用哪次的结果, 另一次的 ctx 会被取消
+// 适合调用下游服务这种偶尔有个别请求很慢的场景, mapper 要能重复执行, 并且要监听 ctx.Done() 才能真正退出
+
+const (
+    // 默认按已完成数据执行时间的 P95 计算阈值
+    defaultSpeculationPercentile = 0.95
+    // 默认至少完成这么多数据后才开始推测执行
+    defaultSpeculationMinSamples = 20
+    // 计算阈值时最多保留最近多少个执行时间
+    speculationSamples = 256
+    // 每完成多少个数据重新计算一次阈值
+    speculationRefresh = 16
+)
+
+// SpeculationConfig 推测执行的参数
+type SpeculationConfig struct {
+    // 执行时间超过已完成数据执行时间的这个分位数时启动备份执行, 取值 0~1, 默认 0.95
+    Percentile float64
+    // 至少完成多少个数据后才开始推测执行, 默认 20
+    MinSamples int
+    // 阈值的下限, 避免本来就很快的数据也启动备份执行
+    MinThreshold time.Duration
+}
+
+func (c *SpeculationConfig) normalize() {
+    if c.Percentile <= 0 || c.Percentile > 1 {
+        c.Percentile = defaultSpeculationPercentile
+    }
+    if c.MinSamples < 1 {
+        c.MinSamples = defaultSpeculationMinSamples
+    }
+}
+
+// latencyTracker 记录最近完成的数据的执行时间, 计算启动备份执行的阈值
+type latencyTracker struct {
+    config SpeculationConfig
+
+    lock      sync.Mutex
+    samples   []time.Duration
+    next      int
+    completed int
+    // 当前的阈值, 0 表示样本还不够
+    limit     int64
+}
+
+func newLatencyTracker(config SpeculationConfig) *latencyTracker {
+    config.normalize()
+    return &latencyTracker{config: config}
+}
+
+func (t *latencyTracker) threshold() time.Duration {
+    return time.Duration(atomic.LoadInt64(&t.limit))
+}
+
+func (t *latencyTracker) add(latency time.Duration) {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+
+    if len(t.samples) < speculationSamples {
+        t.samples = append(t.samples, latency)
+    } else {
+        t.samples[t.next] = latency
+        t.next = (t.next + 1) % speculationSamples
+    }
+    t.completed++
+    if t.completed < t.config.MinSamples || (t.completed-t.config.MinSamples)%speculationRefresh != 0 {
+        return
+    }
+
+    sorted := make([]time.Duration, len(t.samples))
+    copy(sorted, t.samples)
+    sort.Slice(sorted, func(i, j int) bool {
+        return sorted[i] < sorted[j]
+    })
+    limit := percentile(sorted, t.config.Percentile)
+    if limit < t.config.MinThreshold {
+        limit = t.config.MinThreshold
+    }
+    if limit <= 0 {
+        // 0 表示不推测执行, 执行时间都是 0 时也等一会儿再启动
+        limit = 1
+    }
+    atomic.StoreInt64(&t.limit, int64(limit))
+}
+
+// attemptResult 一次执行的结果
+type attemptResult[U any] struct {
+    vals     []U
+    failed   bool
+    err      error
+    // mapper panic 了, err 是 *PanicError
+    panicked bool
+}
+
+// speculativeMapper 执行时间超过阈值时再启动一次执行, 先成功的结果写给 writer
+// 两次都失败时按先失败的那次调用 cancel, 有一次 panic 时马上把 *PanicError 交给 cancel;
+// 只有成功执行的数据的执行时间会被用来计算阈值
+func speculativeMapper[T, U any](mapper MapperFuncOf[T, U], config SpeculationConfig) MapperFuncOf[T, U] {
+    tracker := newLatencyTracker(config)
+    return func(ctx context.Context, item T, writer WriterOf[U], cancel func(err error)) {
+        start := time.Now()
+        threshold := tracker.threshold()
+        if threshold <= 0 {
+            var failed int32
+            mapper(ctx, item, writer, func(err error) {
+                atomic.StoreInt32(&failed, 1)
+                cancel(err)
+            })
+            if atomic.LoadInt32(&failed) == 0 && ctx.Err() == nil {
+                tracker.add(time.Since(start))
+            }
+            return
+        }
+
+        // 两次执行都可能写入, 缓冲 2 个, 输的那次不会阻塞
+        results := make(chan attemptResult[U], 2)
+        run := func(ctx context.Context) {
+            var (
+                lock sync.Mutex
+                res  attemptResult[U]
+            )
+            w := new(sliceWriter[U])
+            defer func() {
+                lock.Lock()
+                res.vals = w.vals
+                results <- res
+                lock.Unlock()
+            }()
+            attemptCancel := func(err error) {
+                lock.Lock()
+                defer lock.Unlock()
+                if !res.failed {
+                    res.failed = true
+                    res.err = err
+                }
+            }
+            defer recoverToCancel(func(err error) {
+                lock.Lock()
+                defer lock.Unlock()
+                res.failed = true
+                res.err = err
+                res.panicked = true
+            })
+            mapper(ctx, item, w, attemptCancel)
+        }
+
+        primaryCtx, primaryCancel := context.WithCancel(ctx)
+        defer primaryCancel()
+        go run(primaryCtx)
+
+        timer := time.NewTimer(threshold)
+        defer timer.Stop()
+        running := 1
+        var first *attemptResult[U]
+        for running > 0 {
+            select {
+            case <-ctx.Done():
+                // 任务已经结束了
+                return
+            case <-timer.C:
+                backupCtx, backupCancel := context.WithCancel(ctx)
+                defer backupCancel()
+                go run(backupCtx)
+                running++
+                if stats := statsFromContext(ctx); stats != nil {
+                    atomic.AddInt64(&stats.speculated, 1)
+                }
+            case res := <-results:
+                running--
+                if !res.failed {
+                    // 先成功的赢, 返回时 defer 会取消另一次执行
+                    tracker.add(time.Since(start))
+                    for _, val := range res.vals {
+                        writer.Writer(val)
+                    }
+                    return
+                }
+                // panic 不等另一次执行, 直接交给 cancel, 和没有开启推测执行时一样
+                if res.panicked {
+                    cancel(res.err)
+                    return
+                }
+                // 还没启动备份执行时失败了就不再备份, 两次都失败时用先失败的那次的错误
+                if first == nil {
+                    first = &res
+                }
+            }
+        }
+        cancel(first.err)
+    }
+}
@@ -0,0 +1,88 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestMapReduceWithSpeculation(t *testing.T) {
+    var (
+        attempts sync.Map
+        canceled int32
+        stats    Stats
+    )
+    res, err := MapReduceOf(context.Background(), generateInts(100), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        n, _ := attempts.LoadOrStore(item, new(int32))
+        // 第 80 个数据第一次执行时一直卡住, 只有备份执行能完成
+        if item == 80 && atomic.AddInt32(n.(*int32), 1) == 1 {
+            <-ctx.Done()
+            atomic.AddInt32(&canceled, 1)
+            return
+        }
+        time.Sleep(time.Millisecond)
+        writer.Writer(item)
+    }, countInts, WithWorkers(4), WithSpeculation(SpeculationConfig{MinSamples: 10}),
+        WithJobTimeout(5*time.Second), WithStats(&stats))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res != 100 {
+        t.Fatalf("want 100, got %d", res)
+    }
+    if stats.Speculated < 1 {
+        t.Fatalf("want speculative attempts, got %d", stats.Speculated)
+    }
+    // 输的那次执行的 ctx 要被取消
+    time.Sleep(10 * time.Millisecond)
+    if atomic.LoadInt32(&canceled) != 1 {
+        t.Fatal("want the slow attempt canceled")
+    }
+}
+
+func TestMapReduceWithSpeculationFail(t *testing.T) {
+    errDummy := errors.New("dummy")
+    var attempts int32
+    _, err := MapReduceOf(context.Background(), generateInts(50), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        if item == 40 {
+            atomic.AddInt32(&attempts, 1)
+            time.Sleep(50 * time.Millisecond)
+            cancel(errDummy)
+            return
+        }
+        writer.Writer(item)
+    }, countInts, WithWorkers(1), WithSpeculation(SpeculationConfig{MinSamples: 10}))
+    if err != errDummy {
+        t.Fatalf("want %v, got %v", errDummy, err)
+    }
+    if n := atomic.LoadInt32(&attempts); n != 2 {
+        t.Fatalf("want 2 attempts, got %d", n)
+    }
+}
+
+func TestMapReduceWithSpeculationPanic(t *testing.T) {
+    var attempts int32
+    _, err := MapReduceOf(context.Background(), generateInts(50), func(ctx context.Context, item int,
+        writer WriterOf[int], cancel func(err error)) {
+        if item == 40 {
+            atomic.AddInt32(&attempts, 1)
+            time.Sleep(20 * time.Millisecond)
+            panic("speculation")
+        }
+        writer.Writer(item)
+    }, countInts, WithWorkers(1), WithSpeculation(SpeculationConfig{MinSamples: 10}),
+        WithRetry(RetryPolicy{MaxAttempts: 3}))
+    var pe *PanicError
+    if !errors.As(err, &pe) || pe.Value != "speculation" {
+        t.Fatalf("want *PanicError, got %v", err)
+    }
+    // 第一次 panic 时备份执行可能已经开始了, 但是不能重试
+    if n := atomic.LoadInt32(&attempts); n > 2 {
+        t.Fatalf("want panics not retried, got %d attempts", n)
+    }
+}
@@ -0,0 +1,148 @@
+package mr
+
+import (
+    "context"
+    "math/rand"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    // 计算延迟分位数时最多保留的样本数, 超过后随机替换, 保证内存不会随数据量增长
+    latencySamples = 1024
+)
+
+// Stats 任务结束时的统计信息, 通过 WithStats 或者 Observer.OnJobEnd 获取
+// 计数是所有 mapper 阶段加起来的, 任务返回时还没结束的 mapper 不在里面
+type Stats struct {
+    // mapper 的并发数上限, 开启自适应并发时是任务结束时的值
+    // Pipeline 有多个阶段时是最后启动的那个阶段的值
+    Workers int
+    // 第一个 mapper 阶段从 generate 拿到的数据个数
+    Generated int64
+    // mapper 成功执行完的次数
+    Mapped int64
+    // mapper 调用 cancel 或者 panic 的次数
+    Failed int64
+    // 任务已经结束时才执行完的 mapper 个数, 它们的结果不会被用到
+    Canceled int64
+    // 开启 WithRetry 时重试的总次数
+    Retries int64
+    // 开启 WithSpeculation 时启动备份执行的次数
+    Speculated int64
+    // mapper 的执行时间
+    Latency LatencyStats
+    // reducer 等到所有 mapper 结果的时间, 从任务开始算, 任务返回时 mapper 还没结束则为 0
+    ReduceWait time.Duration
+    // 整个任务的执行时间
+    Duration time.Duration
+}
+
+// LatencyStats 延迟的统计, 数据很多时分位数是根据采样计算的近似值
+type LatencyStats struct {
+    Mean time.Duration
+    P50  time.Duration
+    P90  time.Duration
+    P99  time.Duration
+    Max  time.Duration
+}
+
+type statsKey struct{}
+
+// jobStats 运行时的统计数据, 任务结束后汇总到 Stats
+type jobStats struct {
+    workers    int64
+    generated  int64
+    mapped     int64
+    failed     int64
+    canceled   int64
+    retries    int64
+    speculated int64
+    // 第一个 mapper 阶段的计数, 用来计算进度
+    firstMapped int64
+    firstFailed int64
+    reduceWait int64
+
+    lock    sync.Mutex
+    count   int64
+    sum     time.Duration
+    max     time.Duration
+    samples []time.Duration
+}
+
+// statsFromContext 返回 ctx 所在任务的统计数据, 任务没有开启统计时返回 nil
+func statsFromContext(ctx context.Context) *jobStats {
+    stats, _ := ctx.Value(statsKey{}).(*jobStats)
+    return stats
+}
+
+func (s *jobStats) addLatency(latency time.Duration) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    s.count++
+    s.sum += latency
+    if latency > s.max {
+        s.max = latency
+    }
+    // 蓄水池采样
+    if len(s.samples) < latencySamples {
+        s.samples = append(s.samples, latency)
+    } else if i := rand.Int63n(s.count); i < latencySamples {
+        s.samples[i] = latency
+    }
+}
+
+// stageDone 一个 mapper 阶段结束了, 记录 reducer 等待的时间, 多个阶段时取最后结束的
+func (s *jobStats) stageDone(elapsed time.Duration) {
+    for {
+        old := atomic.LoadInt64(&s.reduceWait)
+        if int64(elapsed) <= old || atomic.CompareAndSwapInt64(&s.reduceWait, old, int64(elapsed)) {
+            return
+        }
+    }
+}
+
+func (s *jobStats) fill(stats *Stats) {
+    stats.Workers = int(atomic.LoadInt64(&s.workers))
+    stats.Generated = atomic.LoadInt64(&s.generated)
+    stats.Mapped = atomic.LoadInt64(&s.mapped)
+    stats.Failed = atomic.LoadInt64(&s.failed)
+    stats.Canceled = atomic.LoadInt64(&s.canceled)
+    stats.Retries = atomic.LoadInt64(&s.retries)
+    stats.Speculated = atomic.LoadInt64(&s.speculated)
+    stats.ReduceWait = time.Duration(atomic.LoadInt64(&s.reduceWait))
+
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    stats.Latency = LatencyStats{}
+    if s.count == 0 {
+        return
+    }
+    samples := make([]time.Duration, len(s.samples))
+    copy(samples, s.samples)
+    sort.Slice(samples, func(i, j int) bool {
+        return samples[i] < samples[j]
+    })
+    stats.Latency = LatencyStats{
+        Mean: s.sum / time.Duration(s.count),
+        P50:  percentile(samples, 0.5),
+        P90:  percentile(samples, 0.9),
+        P99:  percentile(samples, 0.99),
+        Max:  s.max,
+    }
+}
+
+// percentile sorted 要先排好序
+func percentile(sorted []time.Duration, p float64) time.Duration {
+    i := int(float64(len(sorted))*p+0.5) - 1
+    if i < 0 {
+        i = 0
+    }
+    if i >= len(sorted) {
+        i = len(sorted) - 1
+    }
+    return sorted[i]
+}
@@ -0,0 +1,99 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "time"
+)
+
+var (
+    // ErrTimeout 整个任务超过了 WithJobTimeout 设置的时间
+    ErrTimeout = errors.New("mapreduce timeout")
+    // ErrItemTimeout 处理单个数据超过了 WithItemTimeout 设置的时间
+    ErrItemTimeout = errors.New("mapreduce item timeout")
+)
+
+// TimeoutPolicy 决定单个数据超时后怎样处理
+type TimeoutPolicy int
+
+const (
+    // TimeoutFail 超时的数据按 cancel(ErrItemTimeout) 处理, 默认会停止任务
+    TimeoutFail TimeoutPolicy = iota
+    // TimeoutSkip 跳过超时的数据, 任务继续, 开启死信时会写入死信
+    TimeoutSkip
+)
+
+// abandonable 在数据超时后丢弃 mapper 后续的写入和 cancel
+type abandonable[U any] struct {
+    lock      sync.Mutex
+    abandoned bool
+    writer    WriterOf[U]
+    cancel    func(err error)
+}
+
+func (a *abandonable[U]) Writer(val U) {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+    if !a.abandoned {
+        a.writer.Writer(val)
+    }
+}
+
+func (a *abandonable[U]) Cancel(err error) {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+    if !a.abandoned {
+        a.cancel(err)
+    }
+}
+
+func (a *abandonable[U]) abandon() {
+    a.lock.Lock()
+    a.abandoned = true
+    a.lock.Unlock()
+}
+
+// timeoutMapper 限制 mapper 处理单个数据的时间
+// 超时后不再等待 mapper, 它的 ctx 会被取消, 但 mapper 要自己监听 ctx.Done() 才能真正退出
+func timeoutMapper[T, U any](mapper MapperFuncOf[T, U], timeout time.Duration, policy TimeoutPolicy) MapperFuncOf[T, U] {
+    return func(ctx context.Context, item T, writer WriterOf[U], cancel func(err error)) {
+        // 不用 context.WithTimeout, 要先丢弃 mapper 的写入再取消 ctx, 不然 mapper 被唤醒后还能写入
+        itemCtx, itemCancel := context.WithCancel(ctx)
+        defer itemCancel()
+        timer := time.NewTimer(timeout)
+        defer timer.Stop()
+
+        guard := &abandonable[U]{writer: writer, cancel: cancel}
+        finished := make(chan struct{})
+        go func() {
+            defer close(finished)
+            defer recoverToCancel(guard.Cancel)
+            mapper(itemCtx, item, guard, guard.Cancel)
+        }()
+
+        select {
+        case <-finished:
+            return
+        case <-ctx.Done():
+        case <-timer.C:
+        }
+        guard.abandon()
+        itemCancel()
+        select {
+        case <-finished:
+            // 刚好执行完了, 不算超时
+            return
+        default:
+        }
+        // 任务已经结束了, 不是这个数据超时
+        if ctx.Err() != nil {
+            return
+        }
+        if policy == TimeoutFail {
+            cancel(ErrItemTimeout)
+        } else {
+            skipItem(ctx, ErrItemTimeout)
+        }
+    }
+}
@@ -0,0 +1,75 @@
+package mr
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+)
+
+func hangOn(item int) MapperFuncOf[int, int] {
+    return func(ctx context.Context, i int, writer WriterOf[int], cancel func(err error)) {
+        if i == item {
+            <-ctx.Done()
+        }
+        writer.Writer(i)
+    }
+}
+
+func countInts(ctx context.Context, pipe <-chan int, writer WriterOf[int], cancel func(err error)) {
+    var n int
+    for range pipe {
+        n++
+    }
+    writer.Writer(n)
+}
+
+func generateInts(n int) GenerateFuncOf[int] {
+    return func(ctx context.Context, source chan<- int, cancel func(err error)) {
+        for i := 0; i < n; i++ {
+            source <- i
+        }
+    }
+}
+
+func TestMapReduceWithItemTimeout(t *testing.T) {
+    _, err := MapReduceOf(context.Background(), generateInts(10), hangOn(5), countInts,
+        WithItemTimeout(10*time.Millisecond))
+    if err != ErrItemTimeout {
+        t.Fatalf("want %v, got %v", ErrItemTimeout, err)
+    }
+
+    res, err := MapReduceOf(context.Background(), generateInts(10), hangOn(5), countInts,
+        WithItemTimeout(10*time.Millisecond), WithTimeoutPolicy(TimeoutSkip))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res != 9 {
+        t.Fatalf("want 9, got %d", res)
+    }
+}
+
+func TestMapOrderedWithItemTimeoutSkip(t *testing.T) {
+    res, err := MapOrdered(context.Background(), generateInts(10), hangOn(5),
+        WithItemTimeout(10*time.Millisecond), WithTimeoutPolicy(TimeoutSkip), WithReorderWindow(2))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []int{0, 1, 2, 3, 4, 6, 7, 8, 9}
+    if len(res) != len(want) {
+        t.Fatalf("want %v, got %v", want, res)
+    }
+    for i := range want {
+        if res[i] != want[i] {
+            t.Fatalf("want %v, got %v", want, res)
+        }
+    }
+}
+
+func TestMapReduceWithJobTimeout(t *testing.T) {
+    _, err := MapReduceOf(context.Background(), generateInts(10), hangOn(5), countInts,
+        WithJobTimeout(10*time.Millisecond))
+    if !errors.Is(err, ErrTimeout) {
+        t.Fatalf("want %v, got %v", ErrTimeout, err)
+    }
+}
@@ -61,21 +61,40 @@ func (s *svrConn) healthy() bool {
 
 // 1. 首先要实现 grpc/balancer/base.PickerBuilder 这个接口
 
-type p2cEwmaPickerBuilder struct{}
+// p2cEwmaPickerBuilder 每个 ClientConn 一个, 保存每个 SubConn 的统计数据
+// 解析结果或者连接状态变化时都会重新 Build, 统计数据要留下来, 不然 ewma 永远算不准
+// 只有 SubConn 被删除时才删掉它的统计数据
+type p2cEwmaPickerBuilder struct {
+    lock  sync.Mutex
+    conns map[balancer.SubConn]*svrConn
+}
+
+func newPickerBuilder() *p2cEwmaPickerBuilder {
+    return &p2cEwmaPickerBuilder{
+        conns: make(map[balancer.SubConn]*svrConn),
+    }
+}
 
 func (b *p2cEwmaPickerBuilder) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
     log.Println("start p2c build")
     if len(buildInfo.ReadySCs) == 0 {
         return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
     }
-    //保存所有的连接
+    b.lock.Lock()
+    defer b.lock.Unlock()
+    //保存所有的连接, 已经有的连接沿用之前的统计数据
     var allConn []*svrConn
     for k,v := range buildInfo.ReadySCs {
-        allConn = append(allConn, &svrConn{
-            addr: v.Address,
-            conn: k,
-            success: initSuccess,
-        })
+        conn, ok := b.conns[k]
+        if !ok {
+            conn = &svrConn{
+                addr: v.Address,
+                conn: k,
+                success: initSuccess,
+            }
+            b.conns[k] = conn
+        }
+        allConn = append(allConn, conn)
     }
     
     return &picker{
@@ -84,6 +103,38 @@ func (b *p2cEwmaPickerBuilder) Build(buildInfo base.PickerBuildInfo) balancer.Pi
     }
 }
 
+// remove SubConn 被删除了, 它的统计数据也不用了
+// 还没结束的请求依然会更新这个 svrConn, 不过不会再被选中了
+func (b *p2cEwmaPickerBuilder) remove(sc balancer.SubConn) {
+    b.lock.Lock()
+    delete(b.conns, sc)
+    b.lock.Unlock()
+}
+
+// p2cEwmaBuilder 给每个 ClientConn 创建自己的 p2cEwmaPickerBuilder, 其它的交给 base 包处理
+type p2cEwmaBuilder struct{}
+
+func (*p2cEwmaBuilder) Name() string {
+    return BalancerName
+}
+
+func (*p2cEwmaBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+    pickerBuilder := newPickerBuilder()
+    return base.NewBalancerBuilder(BalancerName, pickerBuilder, base.Config{HealthCheck: true}).
+        Build(&clientConn{ClientConn: cc, pickerBuilder: pickerBuilder}, opts)
+}
+
+// clientConn base 包删除 SubConn 时要经过这里, 顺便删掉它的统计数据
+type clientConn struct {
+    balancer.ClientConn
+    pickerBuilder *p2cEwmaPickerBuilder
+}
+
+func (c *clientConn) RemoveSubConn(sc balancer.SubConn) {
+    c.pickerBuilder.remove(sc)
+    c.ClientConn.RemoveSubConn(sc)
+}
+
 
 type picker struct {
     conns []*svrConn
@@ -191,7 +242,7 @@ func (p *picker) choose(c1, c2 *svrConn) *svrConn {
 }
 
 func newBuilder() balancer.Builder {
-    return base.NewBalancerBuilder(BalancerName, new(p2cEwmaPickerBuilder), base.Config{HealthCheck: true})
+    return new(p2cEwmaBuilder)
 }
 
 func init() {
@@ -0,0 +1,69 @@
+package balance
+
+import (
+    "testing"
+
+    "google.golang.org/grpc/balancer"
+    "google.golang.org/grpc/balancer/base"
+    "google.golang.org/grpc/resolver"
+)
+
+type testSubConn struct {
+    balancer.SubConn
+    addr string
+}
+
+type testClientConn struct {
+    balancer.ClientConn
+    removed []balancer.SubConn
+}
+
+func (c *testClientConn) RemoveSubConn(sc balancer.SubConn) {
+    c.removed = append(c.removed, sc)
+}
+
+func buildInfo(scs ...*testSubConn) base.PickerBuildInfo {
+    ready := make(map[balancer.SubConn]base.SubConnInfo)
+    for _, sc := range scs {
+        ready[sc] = base.SubConnInfo{Address: resolver.Address{Addr: sc.addr}}
+    }
+    return base.PickerBuildInfo{ReadySCs: ready}
+}
+
+func TestPickerBuilderKeepsStats(t *testing.T) {
+    sc1, sc2 := &testSubConn{addr: "a"}, &testSubConn{addr: "b"}
+    builder := newPickerBuilder()
+
+    res, err := builder.Build(buildInfo(sc1)).Pick(balancer.PickInfo{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    // 请求还没结束时重新 Build, 结束后要更新到同一个统计数据上
+    builder.Build(buildInfo(sc1, sc2))
+    res.Done(balancer.DoneInfo{})
+
+    stats := builder.conns[sc1]
+    if stats.requests != 1 || stats.inflight != 0 || stats.last == 0 {
+        t.Fatalf("stats were reset: %+v", stats)
+    }
+    if p := builder.Build(buildInfo(sc1, sc2)).(*picker); len(p.conns) != 2 {
+        t.Fatalf("want 2 conns, got %d", len(p.conns))
+    }
+    for _, conn := range builder.Build(buildInfo(sc1, sc2)).(*picker).conns {
+        if conn.conn == sc1 && conn != stats {
+            t.Fatal("want the same stats after rebuild")
+        }
+    }
+
+    cc := &testClientConn{}
+    (&clientConn{ClientConn: cc, pickerBuilder: builder}).RemoveSubConn(sc1)
+    if len(cc.removed) != 1 || cc.removed[0] != sc1 {
+        t.Fatal("want RemoveSubConn passed to the ClientConn")
+    }
+    if _, ok := builder.conns[sc1]; ok {
+        t.Fatal("want stats pruned after the SubConn is removed")
+    }
+    if _, ok := builder.conns[sc2]; !ok {
+        t.Fatal("want stats of other SubConns kept")
+    }
+}